@@ -0,0 +1,230 @@
+package lightcable
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Admin request timeout, server maybe closed
+const adminTimeout = 10 * time.Second
+
+// Admin history messages default limit
+const defaultHistoryLimit = 100
+
+// Admin is a http.Handler, manage a running server rooms and connections
+// Need 'Authorization: Bearer <token>' header
+//
+//	GET    /rooms                          list rooms
+//	GET    /rooms/{room}/clients           list the room clients
+//	DELETE /rooms/{room}/clients/{name}    kick the room name is this clients
+//	PUT    /rooms/{room}                   create a persistent room
+//	DELETE /rooms/{room}                   delete the room, close its clients
+//	GET    /rooms/{room}/messages          the room history messages, need Config.Store
+//	                                       ?since=RFC3339 time&limit=100
+//
+// room is websocket URL path, "/rooms/xxx/clients" => room: "/xxx"
+type Admin struct {
+	server *Server
+	token  string
+}
+
+// RoomInfo is Admin rooms list item
+type RoomInfo struct {
+	Room    string `json:"room"`
+	Clients int    `json:"clients"`
+}
+
+// ClientInfo is Admin room clients list item
+type ClientInfo struct {
+	Name        string    `json:"name"`
+	Room        string    `json:"room"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// NewAdmin creates a new Admin, manage this server
+// token is empty, reject all requests
+func NewAdmin(server *Server, token string) *Admin {
+	return &Admin{
+		server: server,
+		token:  token,
+	}
+}
+
+// ServeHTTP Interface 'http.Handler'.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.auth(r) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	if r.URL.Path == "/rooms" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		a.listRooms(ctx, w)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/rooms/") {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/rooms")
+
+	switch {
+	case strings.HasSuffix(path, "/messages"):
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		a.history(w, r, strings.TrimSuffix(path, "/messages"))
+	case strings.HasSuffix(path, "/clients"):
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		a.listClients(ctx, w, strings.TrimSuffix(path, "/clients"))
+	case strings.Contains(path, "/clients/"):
+		if r.Method != http.MethodDelete {
+			methodNotAllowed(w, http.MethodDelete)
+			return
+		}
+		i := strings.LastIndex(path, "/clients/")
+		count, err := a.server.Kick(ctx, path[:i], path[i+len("/clients/"):])
+		writeAdminResult(w, count, err)
+	case r.Method == http.MethodPut:
+		if err := a.server.CreateRoom(ctx, path); err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		if r.Method != http.MethodDelete {
+			methodNotAllowed(w, http.MethodPut+", "+http.MethodDelete)
+			return
+		}
+		count, err := a.server.DeleteRoom(ctx, path)
+		writeAdminResult(w, count, err)
+	}
+}
+
+func (a *Admin) auth(r *http.Request) bool {
+	if a.token == "" {
+		return false
+	}
+	auth := []byte(r.Header.Get("Authorization"))
+	return subtle.ConstantTimeCompare(auth, []byte("Bearer "+a.token)) == 1
+}
+
+func (a *Admin) listRooms(ctx context.Context, w http.ResponseWriter) {
+	rooms, err := a.server.Rooms(ctx)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	infos := make([]RoomInfo, 0, len(rooms))
+	for _, room := range rooms {
+		clients, err := a.server.Clients(ctx, room)
+		if err == ErrRoomNotFound {
+			continue
+		} else if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		infos = append(infos, RoomInfo{
+			Room:    room,
+			Clients: len(clients),
+		})
+	}
+	writeJSON(w, infos)
+}
+
+func (a *Admin) listClients(ctx context.Context, w http.ResponseWriter, room string) {
+	clients, err := a.server.Clients(ctx, room)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	infos := make([]ClientInfo, 0, len(clients))
+	for _, client := range clients {
+		info := ClientInfo{
+			Name:        client.Name,
+			Room:        client.Room,
+			ConnectedAt: client.ConnectedAt,
+		}
+		// Virtual client no remote address
+		if addr := client.RemoteAddr(); addr != nil {
+			info.RemoteAddr = addr.String()
+		}
+		infos = append(infos, info)
+	}
+	writeJSON(w, infos)
+}
+
+func writeAdminResult(w http.ResponseWriter, count int, err error) {
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, struct {
+		Clients int `json:"clients"`
+	}{count})
+}
+
+func (a *Admin) history(w http.ResponseWriter, r *http.Request, room string) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultHistoryLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "limit need greater than 0", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := a.server.History(room, since, limit)
+	if err == ErrNoStore {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, records)
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	if err == ErrRoomNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func methodNotAllowed(w http.ResponseWriter, method string) {
+	w.Header().Set("Allow", method)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
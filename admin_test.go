@@ -0,0 +1,115 @@
+package lightcable
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdmin(t *testing.T) {
+	server := New(DefaultConfig)
+	makeConns(t, server, "/test", "/test", "/test-2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	leave := make(chan string, 3)
+	server.OnConnClose(func(c *Client) {
+		leave <- c.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	<-join
+	<-join
+	<-join
+
+	httpServer := httptest.NewServer(NewAdmin(server, "token"))
+	defer httpServer.Close()
+
+	do := func(method, path string, token bool, v interface{}) int {
+		req, err := http.NewRequest(method, httpServer.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+				t.Error(err)
+			}
+		}
+		return res.StatusCode
+	}
+
+	if code := do(http.MethodGet, "/rooms", false, nil); code != http.StatusUnauthorized {
+		t.Error("Should Unauthorized:", code)
+	}
+
+	var rooms []RoomInfo
+	if code := do(http.MethodGet, "/rooms", true, &rooms); code != http.StatusOK {
+		t.Error("Should OK:", code)
+	}
+	if len(rooms) != 2 {
+		t.Errorf("Rooms: %+v", rooms)
+	}
+	for _, room := range rooms {
+		if (room.Room == "/test" && room.Clients != 2) || (room.Room == "/test-2" && room.Clients != 1) {
+			t.Errorf("Room: %+v", room)
+		}
+	}
+
+	var clients []ClientInfo
+	if code := do(http.MethodGet, "/rooms/test/clients", true, &clients); code != http.StatusOK {
+		t.Error("Should OK:", code)
+	}
+	if len(clients) != 2 || clients[0].RemoteAddr == "" || clients[0].ConnectedAt.IsZero() {
+		t.Errorf("Clients: %+v", clients)
+	}
+
+	if code := do(http.MethodGet, "/rooms/none/clients", true, nil); code != http.StatusNotFound {
+		t.Error("Should NotFound:", code)
+	}
+
+	if code := do(http.MethodGet, "/rooms/test/messages", true, nil); code != http.StatusNotImplemented {
+		t.Error("Should NotImplemented without store:", code)
+	}
+
+	name := clients[0].Name
+	var result struct{ Clients int }
+	if code := do(http.MethodDelete, "/rooms/test/clients/"+name, true, &result); code != http.StatusOK || result.Clients != 1 {
+		t.Error("Kick:", code, result)
+	}
+	if n := <-leave; n != name {
+		t.Error("Kick client should:", name, n)
+	}
+
+	if code := do(http.MethodDelete, "/rooms/test-2", true, &result); code != http.StatusOK || result.Clients != 1 {
+		t.Error("Close room:", code, result)
+	}
+	<-leave
+
+	if code := do(http.MethodPut, "/rooms/persistent", true, nil); code != http.StatusNoContent {
+		t.Error("Create room:", code)
+	}
+	if code := do(http.MethodDelete, "/rooms/persistent", true, &result); code != http.StatusOK || result.Clients != 0 {
+		t.Error("Delete room:", code, result)
+	}
+
+	cancel()
+	<-sign
+}
@@ -0,0 +1,74 @@
+package lightcable
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWriteBatch(t *testing.T) {
+	for _, engine := range []Engine{EngineGoroutine, EngineEpoll} {
+		for _, coalesce := range []bool{false, true} {
+			testWriteBatch(t, engine, coalesce)
+		}
+	}
+}
+
+func testWriteBatch(t *testing.T, engine Engine, coalesce bool) {
+	cfg := *DefaultConfig
+	cfg.Engine = engine
+	cfg.Worker.WriteBatch = 16
+	cfg.Worker.Coalesce = coalesce
+	cfg.Worker.Separator = "\n"
+	server := New(&cfg)
+	ws := makeConns(t, server, "/test")[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	<-join
+
+	count := 100
+	for i := 0; i < count; i++ {
+		server.Broadcast("/test", "server", websocket.TextMessage, []byte(strconv.Itoa(i)))
+	}
+	server.Broadcast("/test", "server", websocket.BinaryMessage, []byte("binary"))
+
+	// Coalesced frames, messages keep order
+	var recv []string
+	for len(recv) < count {
+		code, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != websocket.TextMessage {
+			t.Fatal(engine, coalesce, "Type should TextMessage:", code)
+		}
+		recv = append(recv, strings.Split(string(data), "\n")...)
+	}
+	for i, s := range recv {
+		if s != strconv.Itoa(i) {
+			t.Fatalf("%d %t: Should recv %d, but: %q", engine, coalesce, i, s)
+		}
+	}
+
+	// Binary message never coalesce
+	if code, data, err := ws.ReadMessage(); err != nil || code != websocket.BinaryMessage || string(data) != "binary" {
+		t.Error("Should recv binary:", code, string(data), err)
+	}
+
+	cancel()
+	<-sign
+}
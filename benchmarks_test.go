@@ -2,14 +2,32 @@ package lightcable
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
+	"net"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/gorilla/websocket"
 )
 
 func BenchmarkBroadcast(b *testing.B) {
-	server := New(DefaultConfig)
+	for _, pool := range []bool{false, true} {
+		b.Run(fmt.Sprintf("pool_buffers-%t", pool), func(b *testing.B) {
+			benchmarkBroadcast(b, pool)
+		})
+	}
+}
+
+func benchmarkBroadcast(b *testing.B, pool bool) {
+	b.ReportAllocs()
+	cfg := *DefaultConfig
+	cfg.Worker.PoolBuffers = pool
+	server := New(&cfg)
 	conns := makeConns(b, server, "/test", "/test")
 	ws, ws2 := conns[0], conns[1]
 
@@ -54,3 +72,339 @@ func BenchmarkBroadcast(b *testing.B) {
 	cancel()
 	<-sign
 }
+
+// BenchmarkBroadcastShards many rooms broadcast concurrency
+func BenchmarkBroadcastShards(b *testing.B) {
+	for _, shards := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("shards-%d", shards), func(b *testing.B) {
+			benchmarkBroadcastShards(b, shards, 64)
+		})
+	}
+}
+
+func benchmarkBroadcastShards(b *testing.B, shards, count int) {
+	cfg := *DefaultConfig
+	cfg.Shards = shards
+	server := New(&cfg)
+
+	rooms := make([]string, count)
+	for i := range rooms {
+		rooms[i] = fmt.Sprintf("/test-%d", i)
+	}
+	conns := makeConns(b, server, rooms...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	for range rooms {
+		<-join
+	}
+
+	// Only drain, measure server threads
+	for _, conn := range conns {
+		go func(conn *websocket.Conn) {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+
+	data := make([]byte, 64)
+	var n uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			room := rooms[atomic.AddUint64(&n, 1)%uint64(count)]
+			if _, err := server.BroadcastContext(context.Background(), room, "server", websocket.TextMessage, data); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+	b.StopTimer()
+
+	cancel()
+	<-sign
+}
+
+// BenchmarkBroadcastRoom a large room, one message to every clients
+func BenchmarkBroadcastRoom(b *testing.B) {
+	for _, prepared := range []bool{false, true} {
+		for _, compression := range []bool{false, true} {
+			// prepared-false is baseline, every client frame and compress alone
+			b.Run(fmt.Sprintf("prepared-%t/compression-%t", prepared, compression), func(b *testing.B) {
+				benchmarkBroadcastRoom(b, !prepared, compression, 64)
+			})
+		}
+	}
+}
+
+func benchmarkBroadcastRoom(b *testing.B, noPrepare, compression bool, count int) {
+	cfg := *DefaultConfig
+	cfg.Worker.noPrepare = noPrepare
+	cfg.Compression = compression
+	server := New(&cfg)
+
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = compression
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	conns := make([]*websocket.Conn, count)
+	for i := range conns {
+		conn, _, err := dialer.Dial(makeWsProto(httpServer.URL+"/test"), nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns[i] = conn
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	for range conns {
+		<-join
+	}
+
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		go func(conn *websocket.Conn) {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+				wg.Done()
+			}
+		}(conn)
+	}
+
+	data := []byte(strings.Repeat("lightcable ", 400))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(count)
+		if _, err := server.BroadcastContext(context.Background(), "/test", "server", websocket.TextMessage, data); err != nil {
+			b.Error(err)
+		}
+		wg.Wait()
+	}
+	b.StopTimer()
+
+	cancel()
+	<-sign
+}
+
+// countListener count accepted connections Write calls, a Write is a syscall
+type countListener struct {
+	net.Listener
+	writes int64
+}
+
+func (l *countListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	return &countConn{Conn: conn, writes: &l.writes}, err
+}
+
+type countConn struct {
+	net.Conn
+	writes *int64
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	return c.Conn.Write(p)
+}
+
+// BenchmarkWriteBatch burst small messages to a client, writes/op is write syscalls per message
+func BenchmarkWriteBatch(b *testing.B) {
+	for _, batch := range []int{0, 16} {
+		b.Run(fmt.Sprintf("write_batch-%d", batch), func(b *testing.B) {
+			benchmarkWriteBatch(b, batch)
+		})
+	}
+}
+
+func benchmarkWriteBatch(b *testing.B, batch int) {
+	cfg := *DefaultConfig
+	cfg.Worker.WriteBatch = batch
+	server := New(&cfg)
+
+	httpServer := httptest.NewUnstartedServer(server)
+	listener := &countListener{Listener: httpServer.Listener}
+	httpServer.Listener = listener
+	httpServer.Start()
+	defer httpServer.Close()
+	conn, _, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/test"), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+	<-join
+
+	recv := make(chan bool, cfg.Worker.CastBufferCount)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			recv <- true
+		}
+	}()
+
+	// Burst less than client send buffer, avoid the slow client closed
+	const burst = 64
+	data := []byte("lightcable")
+	b.ReportAllocs()
+	b.ResetTimer()
+	atomic.StoreInt64(&listener.writes, 0)
+	for i := 0; i < b.N; i += burst {
+		n := burst
+		if b.N-i < n {
+			n = b.N - i
+		}
+		for j := 0; j < n; j++ {
+			server.Broadcast("/test", "server", websocket.TextMessage, data)
+		}
+		for j := 0; j < n; j++ {
+			<-recv
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&listener.writes))/float64(b.N), "writes/op")
+
+	conn.Close()
+	cancel()
+	<-sign
+}
+
+// BenchmarkConnMemory memory per idle connection, server and client both in process
+// Connections once, every op is a GC and read memory stats
+func BenchmarkConnMemory(b *testing.B) {
+	for _, engine := range []struct {
+		name   string
+		engine Engine
+	}{
+		{"goroutine", EngineGoroutine},
+		{"epoll", EngineEpoll},
+	} {
+		engine := engine
+		b.Run(engine.name, func(b *testing.B) {
+			benchmarkConnMemory(b, engine.engine, 1000)
+		})
+	}
+}
+
+func benchmarkConnMemory(b *testing.B, engine Engine, count int) {
+	cfg := *DefaultConfig
+	cfg.Engine = engine
+	server := New(&cfg)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	conns := make([]*websocket.Conn, count)
+	for i := range conns {
+		conn, _, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/test"), nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns[i] = conn
+		<-join
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+	}
+	b.StopTimer()
+
+	// Dead conns finalizer maybe close the sockets before measured
+	runtime.KeepAlive(conns)
+	used := (after.HeapInuse + after.StackInuse) - (before.HeapInuse + before.StackInuse)
+	b.ReportMetric(float64(used)/float64(count), "B/conn")
+	b.ReportMetric(float64(after.HeapInuse-before.HeapInuse)/float64(count), "heap/conn")
+	b.ReportMetric(float64(after.StackInuse-before.StackInuse)/float64(count), "stack/conn")
+	b.ReportMetric(float64(runtime.NumGoroutine())/float64(count), "goroutines/conn")
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	cancel()
+	<-sign
+}
+
+// BenchmarkTopicMatch a concrete room match many wildcard subscriptions
+func BenchmarkTopicMatch(b *testing.B) {
+	for _, count := range []int{100, 10000} {
+		b.Run(fmt.Sprintf("subscriptions=%d", count), func(b *testing.B) {
+			benchmarkTopicMatch(b, count)
+		})
+	}
+}
+
+func benchmarkTopicMatch(b *testing.B, count int) {
+	b.ReportAllocs()
+	tree := newTopicTree()
+	for i := 0; i < count; i++ {
+		room := fmt.Sprintf("/game/%d/+", i)
+		if i%2 == 0 {
+			room = fmt.Sprintf("/game/+/%d/#", i)
+		}
+		tree.add(room, &worker{room: room})
+	}
+	tree.add("/game/#", &worker{room: "/game/#"})
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		matched := 0
+		for pb.Next() {
+			tree.match("/game/42/chat", func(*worker) { matched++ })
+		}
+		if matched == 0 {
+			b.Error("Should matched")
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package lightcable
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrBotLeft the bot already left the room
+var ErrBotLeft = errors.New("Bot Left The Room")
+
+// Bot is a server side virtual client, join a room without websocket
+// Receive every message the room fan out, same as websocket clients, include the Local rule
+// Use it build moderation bots, recorders and assistants in process
+type Bot struct {
+	*Client
+}
+
+// Join a bot to the room, handler receive the room messages in the bot goroutine
+// WorkerConfig.PoolBuffers, Message.Data is reused after handler, need keep it copy it
+// perm is the bot permission, look SetPermission. Not count in limits. ctx done before joined, the bot leave
+func (s *Server) Join(ctx context.Context, room, name string, perm Permission, handler func(*Message)) (*Bot, error) {
+	client := newClient(room, name, nil, s.config.CastBufferCount)
+	client.Perm = perm
+	client.handler = handler
+	client.ready = make(chan struct{})
+	client.leave = make(chan struct{})
+	client.done = make(chan struct{})
+	if err := s.addClient(client); err != nil {
+		return nil, err
+	}
+
+	bot := &Bot{Client: client}
+	select {
+	case <-client.ready:
+		return bot, nil
+	case <-ctx.Done():
+		bot.Leave()
+		return nil, ctx.Err()
+	}
+}
+
+// Send message to the room as this bot, will callback OnMessage
+// Message.Data is the data, don't modify it after send
+// Bot without PermPublish return ErrForbidden
+func (b *Bot) Send(code int, data []byte) error {
+	c := b.Client
+	if !c.Perm.CanPublish() {
+		return ErrForbidden
+	}
+	msg := Message{
+		Name:   c.Name,
+		Room:   c.Room,
+		Code:   code,
+		Data:   data,
+		client: c,
+	}
+
+	// Bot is a member, the worker keep alive until bot left
+	select {
+	case <-c.done:
+		return ErrBotLeft
+	default:
+	}
+	c.worker.server.onMessage(&msg)
+	c.worker.server.forward(msg)
+	select {
+	case c.worker.broadcast <- msg:
+		return nil
+	case <-c.done:
+		return ErrBotLeft
+	}
+}
+
+// Leave the room, will callback OnConnClose
+func (b *Bot) Leave() {
+	b.once.Do(func() { close(b.leave) })
+}
+
+// Done the bot left the room, kicked or room closed or server closed
+func (b *Bot) Done() <-chan struct{} {
+	return b.done
+}
+
+// botPump deliver the room messages to the handler
+func (c *Client) botPump(ctx context.Context) {
+	defer func() {
+		close(c.done)
+		c.worker.unregister <- c
+	}()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			// The worker closed the channel.
+			if !ok {
+				return
+			}
+			c.handler(&msg)
+			msg.buffer.release()
+		case <-c.leave:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
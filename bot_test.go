@@ -0,0 +1,97 @@
+package lightcable
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBot(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	conns := makeConns(t, server, "/test")
+	ws := conns[0]
+
+	join := make(chan string, 2)
+	server.OnConnReady(func(c *Client) { join <- c.Name })
+	leave := make(chan string, 2)
+	server.OnConnClose(func(c *Client) { leave <- c.Name })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+	<-join
+
+	recv := make(chan string, 4)
+	bot, err := server.Join(context.Background(), "/test", "bot", PermAll, func(m *Message) {
+		recv <- string(m.Data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := <-join; name != "bot" {
+		t.Error("Should join bot:", name)
+	}
+
+	// Websocket client to bot
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Error(err)
+	}
+	if m := <-recv; m != "hi" {
+		t.Error("Bot should recv:", m)
+	}
+
+	// Bot to websocket client, Local rule the bot not recv itself
+	if err := bot.Send(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Error(err)
+	}
+	if _, data, err := ws.ReadMessage(); err != nil || string(data) != "hello" {
+		t.Error("Should recv from bot:", string(data), err)
+	}
+	select {
+	case m := <-recv:
+		t.Error("Bot should not recv itself:", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clients, err := server.Clients(context.Background(), "/test")
+	if err != nil || len(clients) != 2 {
+		t.Error("Clients:", len(clients), err)
+	}
+	if _, err := server.Kick(context.Background(), "/test", "bot"); err != nil {
+		t.Error(err)
+	}
+	<-bot.Done()
+	if name := <-leave; name != "bot" {
+		t.Error("Should leave bot:", name)
+	}
+	if err := bot.Send(websocket.TextMessage, []byte("left")); err != ErrBotLeft {
+		t.Error("Should left:", err)
+	}
+
+	// Leave by self
+	bot, err = server.Join(context.Background(), "/test", "bot-2", PermSubscribe, func(m *Message) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-join
+	if err := bot.Send(websocket.TextMessage, []byte("forbidden")); err != ErrForbidden {
+		t.Error("Should forbidden:", err)
+	}
+	bot.Leave()
+	bot.Leave()
+	if name := <-leave; name != "bot-2" {
+		t.Error("Should leave bot:", name)
+	}
+	if stats := server.Stats(); stats.Conns != 1 {
+		t.Error("Bot should not count in limits:", stats.Conns)
+	}
+
+	cancel()
+	<-leave
+	<-sign
+}
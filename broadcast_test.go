@@ -0,0 +1,158 @@
+package lightcable
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBroadcastContext(t *testing.T) {
+	server := New(DefaultConfig)
+	conns := makeConns(t, server, "/test", "/test", "/test-2")
+	ws, ws2, ws3 := conns[0], conns[1], conns[2]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	<-join
+	<-join
+	<-join
+
+	count, err := server.BroadcastContext(context.Background(), "/test", "server", websocket.TextMessage, []byte("room"))
+	if err != nil || count != 2 {
+		t.Error("BroadcastContext:", count, err)
+	}
+	for _, conn := range []*websocket.Conn{ws, ws2} {
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != "room" {
+			t.Error("Should recv room:", string(data), err)
+		}
+	}
+
+	if count, err := server.BroadcastContext(context.Background(), "/xxx", "server", websocket.TextMessage, []byte("xxx")); err != nil || count != 0 {
+		t.Error("BroadcastContext no room:", count, err)
+	}
+
+	count, err = server.BroadcastAllContext(context.Background(), "server", websocket.TextMessage, []byte("all"))
+	if err != nil || count != 3 {
+		t.Error("BroadcastAllContext:", count, err)
+	}
+	for _, conn := range []*websocket.Conn{ws, ws2, ws3} {
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != "all" {
+			t.Error("Should recv all:", string(data), err)
+		}
+	}
+
+	if count, err := server.TryBroadcast("/test", "server", websocket.TextMessage, []byte("try")); err != nil || count != 2 {
+		t.Error("TryBroadcast:", count, err)
+	}
+	for _, conn := range []*websocket.Conn{ws, ws2} {
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != "try" {
+			t.Error("Should recv try:", string(data), err)
+		}
+	}
+
+	cancel()
+	<-sign
+}
+
+// blockStore Append block until release, stall the room worker
+type blockStore struct {
+	appending chan bool
+	release   chan bool
+}
+
+func (s *blockStore) Append(r *Record) error {
+	select {
+	case s.appending <- true:
+	default:
+	}
+	<-s.release
+	return nil
+}
+
+func (s *blockStore) Query(room string, since time.Time, limit int) ([]Record, error) {
+	return nil, nil
+}
+
+func TestBroadcastBusy(t *testing.T) {
+	store := &blockStore{appending: make(chan bool, 1), release: make(chan bool)}
+	cfg := &Config{
+		SignBufferCount: 1,
+		CastBufferCount: 1,
+		Worker:          DefaultConfig.Worker,
+		Store:           store,
+	}
+	cfg.Worker.CastBufferCount = 1
+	server := New(cfg)
+
+	// server no Run
+	if _, err := server.TryBroadcast("/test", "server", websocket.TextMessage, []byte("xxx")); err != ErrNotRunning {
+		t.Error("Should ErrNotRunning:", err)
+	}
+	if _, err := server.TryBroadcastAll("server", websocket.TextMessage, []byte("xxx")); err != ErrNotRunning {
+		t.Error("Should ErrNotRunning:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	go server.Run(ctx)
+
+	if err := server.CreateRoom(context.Background(), "/test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The room worker stalled, next message full the room buffer
+	server.Broadcast("/test", "server", websocket.TextMessage, []byte("a"))
+	<-store.appending
+	server.Broadcast("/test", "server", websocket.TextMessage, []byte("b"))
+
+	// Busy room drop it, never wait the room
+	if count, err := server.TryBroadcast("/test", "server", websocket.TextMessage, []byte("xxx")); err != ErrDropped || count != 0 {
+		t.Error("Should ErrDropped:", count, err)
+	}
+	if count, err := server.TryBroadcastAll("server", websocket.TextMessage, []byte("xxx")); err != ErrDropped || count != 0 {
+		t.Error("Should ErrDropped:", count, err)
+	}
+	if dropped := server.Stats().Dropped; dropped != 2 {
+		t.Error("Should count dropped:", dropped)
+	}
+
+	// Broadcast block the shard on the busy room, the shard buffer will full
+	server.Broadcast("/test", "server", websocket.TextMessage, []byte("c"))
+	server.Broadcast("/test", "server", websocket.TextMessage, []byte("d"))
+	if _, err := server.TryBroadcast("/test", "server", websocket.TextMessage, []byte("xxx")); err != ErrBufferFull {
+		t.Error("Should ErrBufferFull:", err)
+	}
+
+	// Shard broadcast all buffer full, the server broadcast all buffer will full
+	server.BroadcastAll("server", websocket.TextMessage, []byte("e"))
+	server.BroadcastAll("server", websocket.TextMessage, []byte("f"))
+	server.BroadcastAll("server", websocket.TextMessage, []byte("g"))
+	if _, err := server.TryBroadcastAll("server", websocket.TextMessage, []byte("xxx")); err != ErrBufferFull {
+		t.Error("Should ErrBufferFull:", err)
+	}
+
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelTimeout()
+	if _, err := server.BroadcastContext(timeout, "/test", "server", websocket.TextMessage, []byte("xxx")); err != context.DeadlineExceeded {
+		t.Error("Should DeadlineExceeded:", err)
+	}
+
+	close(store.release)
+	cancel()
+	<-sign
+}
@@ -0,0 +1,56 @@
+package lightcable
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// Large buffer no put back to pool, avoid a large message hold memory
+const maxPoolBuffer = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(buffer)
+	},
+}
+
+// buffer is pooled received message data, reference counted
+// Every recipient hold a reference, release after written
+type buffer struct {
+	refs int32
+	// false is owned by the message, never put back to pool
+	pooled bool
+	bytes.Buffer
+}
+
+func newBuffer() *buffer {
+	b := bufferPool.Get().(*buffer)
+	b.refs = 1
+	b.pooled = true
+	b.Reset()
+	return b
+}
+
+// newMessageBuffer received message buffer, pool is WorkerConfig.PoolBuffers
+// Not pooled, the message data never reused
+func newMessageBuffer(pool bool) *buffer {
+	if pool {
+		return newBuffer()
+	}
+	return &buffer{refs: 1}
+}
+
+// retain a reference, nil is not pooled
+func (b *buffer) retain() {
+	if b != nil {
+		atomic.AddInt32(&b.refs, 1)
+	}
+}
+
+// release a reference, last reference put back to pool
+func (b *buffer) release() {
+	if b != nil && atomic.AddInt32(&b.refs, -1) == 0 && b.pooled && b.Cap() <= maxPoolBuffer {
+		bufferPool.Put(b)
+	}
+}
@@ -0,0 +1,134 @@
+package lightcable
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBuffer(t *testing.T) {
+	buf := newBuffer()
+	buf.WriteString("lightcable")
+	buf.retain()
+	buf.release()
+	if buf.refs != 1 || buf.String() != "lightcable" {
+		t.Error("Should hold a reference:", buf.refs, buf.String())
+	}
+	buf.release()
+
+	// nil is not pooled
+	var none *buffer
+	none.retain()
+	none.release()
+
+	// Message owned buffer never put back to pool
+	owned := newMessageBuffer(false)
+	owned.WriteString("owned")
+	owned.release()
+	if owned.pooled || owned.String() != "owned" {
+		t.Error("Should not pooled:", owned.String())
+	}
+}
+
+func TestBufferNotPooled(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	conns := makeConns(t, server, "/test", "/test")
+	ws, ws2 := conns[0], conns[1]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	// Keep Message.Data, no copy
+	var kept [][]byte
+	server.OnMessage(func(m *Message) {
+		kept = append(kept, m.Data)
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	<-join
+	<-join
+
+	count := 100
+	for i := 0; i < count; i++ {
+		if err := ws.WriteMessage(websocket.TextMessage, bytes.Repeat([]byte(strconv.Itoa(i)), 100)); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := ws2.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cancel()
+	<-sign
+
+	if len(kept) != count {
+		t.Fatal("Should keep messages:", len(kept))
+	}
+	for i, data := range kept {
+		if expect := bytes.Repeat([]byte(strconv.Itoa(i)), 100); !bytes.Equal(data, expect) {
+			t.Fatalf("Kept data %d overwritten: %s", i, data)
+		}
+	}
+}
+
+func TestBufferReuse(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Worker.PoolBuffers = true
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	conns := makeConns(t, server, "/test", "/test", "/test")
+	ws, ws2, ws3 := conns[0], conns[1], conns[2]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	<-join
+	<-join
+	<-join
+
+	// Pooled buffer reused, recipients data must not be overwritten
+	// Less than CastBufferCount, slow client no drop
+	count := 100
+	go func() {
+		for i := 0; i < count; i++ {
+			if err := ws.WriteMessage(websocket.TextMessage, bytes.Repeat([]byte(strconv.Itoa(i)), 100)); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < count; i++ {
+		for _, conn := range []*websocket.Conn{ws2, ws3} {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expect := bytes.Repeat([]byte(strconv.Itoa(i)), 100); !bytes.Equal(data, expect) {
+				t.Fatalf("Should recv %d, but: %s", i, data)
+			}
+		}
+	}
+
+	cancel()
+	<-sign
+}
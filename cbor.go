@@ -0,0 +1,215 @@
+package lightcable
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// cborCodec is CBOR, https://www.rfc-editor.org/rfc/rfc8949.html
+// Tags are ignored, not support indefinite length
+type cborCodec struct{}
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+func (cborCodec) index() int { return 1 }
+
+func (c cborCodec) encode(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if v {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case int64:
+		if v < 0 {
+			return cborHead(b, cborNegint, uint64(-1-v)), nil
+		}
+		return cborHead(b, cborUint, uint64(v)), nil
+	case uint64:
+		return cborHead(b, cborUint, v), nil
+	case float64:
+		return append(append(b, 0xfb), uint64Bytes(math.Float64bits(v))...), nil
+	case json.Number:
+		n, err := jsonNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		return c.encode(b, n)
+	case string:
+		return append(cborHead(b, cborText, uint64(len(v))), v...), nil
+	case []byte:
+		return append(cborHead(b, cborBytes, uint64(len(v))), v...), nil
+	case []interface{}:
+		b = cborHead(b, cborArray, uint64(len(v)))
+		for _, item := range v {
+			var err error
+			if b, err = c.encode(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = cborHead(b, cborMap, uint64(len(v)))
+		for key, item := range v {
+			var err error
+			b = append(cborHead(b, cborText, uint64(len(key))), key...)
+			if b, err = c.encode(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, errCodecType
+}
+
+// cborHead the major type and argument, shortest form
+func cborHead(b []byte, major byte, n uint64) []byte {
+	m := major << 5
+	switch {
+	case n < 24:
+		return append(b, m|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, m|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(b, m|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(b, m|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(append(b, m|27), uint64Bytes(n)...)
+}
+
+func (cborCodec) decode(data []byte) (interface{}, error) {
+	d := &cborDecoder{codecDecoder{b: data}}
+	v := d.value(0)
+	if d.err == nil && len(d.b) != 0 {
+		d.err = errCodecSyntax
+	}
+	return v, d.err
+}
+
+type cborDecoder struct {
+	codecDecoder
+}
+
+func (d *cborDecoder) value(depth int) interface{} {
+	if depth > maxCodecDepth {
+		d.err = errCodecMaxDepth
+		return nil
+	}
+	p := d.next(1)
+	if p == nil {
+		return nil
+	}
+	major, info := p[0]>>5, p[0]&0x1f
+
+	if major == cborSimple {
+		switch info {
+		case 20:
+			return false
+		case 21:
+			return true
+		case 22, 23:
+			// null and undefined
+			return nil
+		case 25:
+			return float16(uint16(d.uint(2)))
+		case 26:
+			return float64(math.Float32frombits(uint32(d.uint(4))))
+		case 27:
+			return math.Float64frombits(d.uint(8))
+		}
+		d.err = errCodecType
+		return nil
+	}
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		n = d.uint(1 << (info - 24))
+	default:
+		// Indefinite length or reserved
+		d.err = errCodecType
+		return nil
+	}
+
+	switch major {
+	case cborUint:
+		return n
+	case cborNegint:
+		if n > math.MaxInt64 {
+			d.err = errCodecType
+			return nil
+		}
+		return -1 - int64(n)
+	case cborBytes:
+		return append([]byte{}, d.next(int(n))...)
+	case cborText:
+		return string(d.next(int(n)))
+	case cborArray:
+		// Every item at least 1 byte
+		if n > uint64(len(d.b)) {
+			d.err = errCodecSyntax
+			return nil
+		}
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			items = append(items, d.value(depth+1))
+		}
+		return items
+	case cborMap:
+		// Every key value at least 2 bytes
+		if n > uint64(len(d.b)/2) {
+			d.err = errCodecSyntax
+			return nil
+		}
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			key, ok := d.value(depth + 1).(string)
+			if !ok {
+				if d.err == nil {
+					d.err = errCodecMapKey
+				}
+				return nil
+			}
+			m[key] = d.value(depth + 1)
+		}
+		return m
+	}
+	// Tag, ignore it
+	return d.value(depth + 1)
+}
+
+// float16 IEEE 754 half precision
+func float16(h uint16) float64 {
+	exp, mant := int(h>>10&0x1f), float64(h&0x3ff)
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 0x1f:
+		v = math.Inf(1)
+		if mant != 0 {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		v = -v
+	}
+	return v
+}
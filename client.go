@@ -2,7 +2,11 @@ package lightcable
 
 import (
 	"context"
+	"io"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -19,10 +23,40 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+func newUpgrader(cfg *Config) *websocket.Upgrader {
+	upgrader := &websocket.Upgrader{
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: cfg.Compression,
+		CheckOrigin: func(r *http.Request) bool {
+			return checkOrigin(cfg.Origins, r.Header.Get("Origin"))
+		},
+	}
+	if cfg.MQTT {
+		upgrader.Subprotocols = append(upgrader.Subprotocols, mqttSubprotocol)
+	}
+	if cfg.Codecs {
+		upgrader.Subprotocols = append(upgrader.Subprotocols, SubprotocolMsgpack, SubprotocolCBOR)
+	}
+	if cfg.Engine == EngineEpoll {
+		// Idle connection no hold write buffer
+		upgrader.WriteBufferPool = &sync.Pool{}
+	}
+	return upgrader
+}
+
+// checkOrigin origins is empty or has "*" allow all
+// No Origin header is not browser, allow it
+func checkOrigin(origins []string, origin string) bool {
+	if len(origins) == 0 || origin == "" {
+		return true
+	}
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
 }
 
 // Message represents a message send and received from the Websocket connection.
@@ -34,23 +68,121 @@ type Message struct {
 	Name string
 	Code int
 	Data []byte
-	conn *websocket.Conn
+
+	// Sender client, nil is server
+	client *Client
+
+	// Forwarded to wildcard room, Room is the concrete room
+	forwarded bool
+
+	// Signaling message only the named peer recv, empty is all clients
+	to string
+
+	// Never block on a busy shard or room, drop it, TryBroadcast
+	try bool
+
+	// receipt count this message delivered clients, maybe nil
+	receipt *receipt
+
+	// prepared frame once by worker, all clients share it, maybe nil
+	prepared *websocket.PreparedMessage
+
+	// Data pooled buffer, nil is not pooled
+	buffer *buffer
 }
 
 // Client is a middleman between the websocket connection and the worker.
 type Client struct {
+	// Last read time unix nano, epoll engine check idle connections by it
+	// atomic int64 store from the read goroutine, the first field keep it 8 byte aligned
+	active int64
+
 	Name string
 	Room string
 
 	Err error
 
+	// Publish and subscribe permission in the room
+	Perm Permission
+
+	// Websocket connection established time
+	ConnectedAt time.Time
+
 	worker *worker
 
+	// Remote IP, for limiter
+	ip string
+
 	// The websocket connection.
 	conn *websocket.Conn
 
 	// Buffered channel of outbound messages.
 	send chan Message
+
+	// Epoll engine, nil is goroutine engine
+	poll *poller
+	fc   *frameConn
+	fd   int
+
+	// Write batching hold frames, nil is disable
+	batch *batchConn
+
+	// Lazy writer is running
+	writing int32
+	once    sync.Once
+
+	// Negotiated codec, nil is no codec
+	codec codec
+
+	// Virtual client handler, nil is websocket client
+	handler func(*Message)
+	// Virtual client registered and left
+	ready chan struct{}
+	leave chan struct{}
+	done  chan struct{}
+}
+
+func newClient(room, name string, conn *websocket.Conn, size int) *Client {
+	return &Client{
+		Room: room,
+		Name: name,
+		Perm: PermAll,
+
+		ConnectedAt: time.Now(),
+
+		conn: conn,
+		send: make(chan Message, size),
+	}
+}
+
+// RemoteAddr returns the remote network address.
+// Virtual client return nil
+func (c *Client) RemoteAddr() net.Addr {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.RemoteAddr()
+}
+
+// start read and write, epoll engine no goroutine until have work
+func (c *Client) start(ctx context.Context) {
+	if c.handler != nil {
+		go c.botPump(ctx)
+		close(c.ready)
+		return
+	}
+	if c.poll != nil && c.poll.add(c) == nil {
+		return
+	}
+	c.poll = nil
+	go c.readPump()
+	go c.writePump(ctx)
+}
+
+// closeSend the worker close the send channel, the client will close
+func (c *Client) closeSend() {
+	close(c.send)
+	c.wake()
 }
 
 // readPump pumps messages from the websocket connection to the worker.
@@ -71,21 +203,223 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		code, data, err := c.conn.ReadMessage()
+		code, buf, err := c.readMessage()
 		if err != nil {
 			c.Err = err
 			break
 		}
-		msg := Message{
-			Name: c.Name,
-			Room: c.Room,
-			Code: code,
-			Data: data,
-			conn: c.conn,
+		c.receive(code, buf)
+	}
+}
+
+// readMessage read a message to buffer, WorkerConfig.PoolBuffers is pooled buffer
+func (c *Client) readMessage() (int, *buffer, error) {
+	code, r, err := c.conn.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+	buf := newMessageBuffer(c.worker.server.config.PoolBuffers)
+	if _, err := buf.ReadFrom(r); err != nil {
+		buf.release()
+		return 0, nil, err
+	}
+	return code, buf, nil
+}
+
+// receive a message from the websocket connection, broadcast to the room
+func (c *Client) receive(code int, buf *buffer) {
+	// No publish permission, drop this message
+	if !c.Perm.CanPublish() {
+		buf.release()
+		return
+	}
+	// Codec client binary message, decode failed drop this message
+	code, buf, ok := c.decode(code, buf)
+	if !ok {
+		return
+	}
+	msg := Message{
+		Name:   c.Name,
+		Room:   c.Room,
+		Code:   code,
+		Data:   buf.Bytes(),
+		client: c,
+		buffer: buf,
+	}
+	// Signaling message is private, not store and forward
+	if c.worker.server.config.Signaling {
+		signal(&msg)
+	}
+	c.worker.server.onMessage(&msg)
+	if msg.to == "" {
+		c.worker.server.forward(msg)
+	}
+	c.worker.broadcast <- msg
+}
+
+// write the message, use prepared frame if have
+func (c *Client) write(msg Message) error {
+	if msg.prepared != nil {
+		return c.conn.WritePreparedMessage(msg.prepared)
+	}
+	return c.conn.WriteMessage(msg.Code, msg.Data)
+}
+
+// drain queued messages append to msgs, up to batch
+// ok is false the worker closed the channel
+func (c *Client) drain(msgs []Message, batch int) ([]Message, bool) {
+	for len(msgs) < batch {
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				return msgs, false
+			}
+			msgs = append(msgs, msg)
+		default:
+			return msgs, true
 		}
-		c.worker.server.onMessage(&msg)
-		c.worker.broadcast <- msg
 	}
+	return msgs, true
+}
+
+// writeBatch write messages in order, all frames flush by one write
+// coalesce is true, consecutive text messages join with separator as a frame
+func (c *Client) writeBatch(msgs []Message, coalesce bool, separator string) (err error) {
+	c.batch.hold()
+	defer func() {
+		if e := c.batch.flush(); err == nil {
+			err = e
+		}
+	}()
+
+	for i := 0; i < len(msgs); {
+		// Only a text message, no need coalesce
+		if !coalesce || msgs[i].Code != websocket.TextMessage ||
+			i+1 == len(msgs) || msgs[i+1].Code != websocket.TextMessage {
+			if err := c.write(msgs[i]); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+
+		w, err := c.conn.NextWriter(websocket.TextMessage)
+		if err != nil {
+			return err
+		}
+		for start := i; i < len(msgs) && msgs[i].Code == websocket.TextMessage; i++ {
+			if i != start {
+				if _, err := io.WriteString(w, separator); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(msgs[i].Data); err != nil {
+				return err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchConn hold written frames of a batch, flush them by one write syscall
+// Concurrent write control frames also hold, keep in order
+type batchConn struct {
+	net.Conn
+
+	mutex   sync.Mutex
+	holding bool
+	buf     *buffer
+}
+
+func (c *batchConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.holding {
+		return c.Conn.Write(p)
+	}
+	c.buf.Write(p)
+	// Large batch, no hold too much memory
+	if c.buf.Len() >= maxPoolBuffer {
+		if err := c.write(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// hold frames until flush, nil is no batching
+func (c *batchConn) hold() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.holding = true
+	c.buf = newBuffer()
+}
+
+// flush held frames and stop holding
+func (c *batchConn) flush() error {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	err := c.write()
+	c.holding = false
+	c.buf.release()
+	c.buf = nil
+	return err
+}
+
+// write held frames, need locked
+func (c *batchConn) write() error {
+	if c.buf.Len() == 0 {
+		return nil
+	}
+	_, err := c.Conn.Write(c.buf.Bytes())
+	c.buf.Reset()
+	return err
+}
+
+// flushMessage write msg to the websocket connection, write batching drain queued messages
+// ok is false the worker closed the channel, write close message
+// msgs is buffer for reuse
+func (c *Client) flushMessage(msg Message, ok bool, msgs []Message) ([]Message, bool, error) {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return msgs, ok, err
+	}
+
+	config := c.worker.server.config
+	if ok && config.WriteBatch > 1 {
+		// A write deadline for batch messages
+		msgs, ok = c.drain(append(msgs[:0], msg), config.WriteBatch)
+		err := c.writeBatch(msgs, config.Coalesce, config.Separator)
+
+		// Release messages data
+		for i := range msgs {
+			msgs[i].buffer.release()
+			msgs[i] = Message{}
+		}
+		if err != nil {
+			return msgs, ok, err
+		}
+	} else if ok {
+		err := c.write(msg)
+		msg.buffer.release()
+		if err != nil {
+			return msgs, ok, err
+		}
+	}
+
+	if !ok {
+		// The worker closed the channel.
+		return msgs, false, c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+	}
+	return msgs, true, nil
 }
 
 // writePump pumps messages from the worker to the websocket connection.
@@ -99,22 +433,16 @@ func (c *Client) writePump(ctx context.Context) {
 		ticker.Stop()
 		c.conn.Close()
 	}()
+	var msgs []Message
 	for {
 		select {
 		case msg, ok := <-c.send:
-			if err := c.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+			var err error
+			if msgs, ok, err = c.flushMessage(msg, ok, msgs); err != nil {
 				c.Err = err
-			}
-			if !ok {
-				// The worker closed the channel.
-				if err := c.conn.WriteMessage(websocket.CloseMessage, []byte{}); err != nil {
-					c.Err = err
-				}
 				return
 			}
-
-			if err := c.conn.WriteMessage(msg.Code, msg.Data); err != nil {
-				c.Err = err
+			if !ok {
 				return
 			}
 
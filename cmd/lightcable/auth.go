@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// authenticator auth websocket connection, room is URL path
+type authenticator interface {
+	auth(r *http.Request, room string) (name string, err error)
+}
+
+func newAuthenticator(mode, keyFile, alg string) (authenticator, error) {
+	if mode == "none" {
+		return nil, nil
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case "jwt":
+		return newJWTAuth(alg, key)
+	case "token":
+		return newTokenAuth(key)
+	case "hmac":
+		return &hmacAuth{key: bytes.TrimSpace(key)}, nil
+	}
+	return nil, fmt.Errorf("Unknown auth mode: %s", mode)
+}
+
+// requestToken from query "?token=xxx" or header "Authorization: Bearer xxx"
+// Browser websocket API can't set header, so need query
+func requestToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// allowRoom rooms is empty or has "*" allow all rooms
+func allowRoom(rooms []string, room string) bool {
+	if len(rooms) == 0 {
+		return true
+	}
+	for _, r := range rooms {
+		if r == room || r == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// jwtAuth validation JWT, support HS256 and RS256
+//
+// claims:
+//
+//	{"name": "client name, default sub", "rooms": ["/room"], "exp": 0, "nbf": 0}
+type jwtAuth struct {
+	alg       string
+	secret    []byte
+	publicKey *rsa.PublicKey
+}
+
+type jwtClaims struct {
+	Sub   string   `json:"sub"`
+	Name  string   `json:"name"`
+	Rooms []string `json:"rooms"`
+	Exp   int64    `json:"exp"`
+	Nbf   int64    `json:"nbf"`
+}
+
+func newJWTAuth(alg string, key []byte) (*jwtAuth, error) {
+	switch alg {
+	case "HS256":
+		return &jwtAuth{alg: alg, secret: bytes.TrimSpace(key)}, nil
+	case "RS256":
+		block, _ := pem.Decode(key)
+		if block == nil {
+			return nil, errors.New("RS256 key need PEM format public key")
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("RS256 key need RSA public key")
+		}
+		return &jwtAuth{alg: alg, publicKey: rsaKey}, nil
+	}
+	return nil, fmt.Errorf("Unknown JWT alg: %s", alg)
+}
+
+func (a *jwtAuth) auth(r *http.Request, room string) (string, error) {
+	parts := strings.Split(requestToken(r), ".")
+	if len(parts) != 3 {
+		return "", errors.New("JWT format error")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	// Must the same as configured, else maybe use public key as HMAC secret
+	if header.Alg != a.alg {
+		return "", fmt.Errorf("JWT alg need %s, but: %s", a.alg, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	if err := a.verify(parts[0]+"."+parts[1], signature); err != nil {
+		return "", err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	now := time.Now().Unix()
+	if claims.Exp != 0 && now >= claims.Exp {
+		return "", errors.New("JWT expired")
+	}
+	if claims.Nbf != 0 && now < claims.Nbf {
+		return "", errors.New("JWT not valid yet")
+	}
+	if !allowRoom(claims.Rooms, room) {
+		return "", fmt.Errorf("JWT not allow room: %s", room)
+	}
+	if claims.Name != "" {
+		return claims.Name, nil
+	}
+	if claims.Sub != "" {
+		return claims.Sub, nil
+	}
+	return "", errors.New("JWT need claim name or sub")
+}
+
+func (a *jwtAuth) verify(signingString string, signature []byte) error {
+	if a.alg == "HS256" {
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write([]byte(signingString))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return errors.New("JWT signature invalid")
+		}
+		return nil
+	}
+	sum := sha256.Sum256([]byte(signingString))
+	return rsa.VerifyPKCS1v15(a.publicKey, crypto.SHA256, sum[:], signature)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// tokenAuth static token file, every line:
+//
+//	<token> <name> [room...]
+//
+// room is empty allow all rooms, "#" is comment
+type tokenAuth struct {
+	tokens []tokenEntry
+}
+
+type tokenEntry struct {
+	token string
+	name  string
+	rooms []string
+}
+
+func newTokenAuth(data []byte) (*tokenAuth, error) {
+	a := &tokenAuth{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("Token file line %d: need '<token> <name> [room...]'", line)
+		}
+		a.tokens = append(a.tokens, tokenEntry{
+			token: fields[0],
+			name:  fields[1],
+			rooms: fields[2:],
+		})
+	}
+	return a, scanner.Err()
+}
+
+func (a *tokenAuth) auth(r *http.Request, room string) (string, error) {
+	token := []byte(requestToken(r))
+	for _, entry := range a.tokens {
+		if subtle.ConstantTimeCompare(token, []byte(entry.token)) == 1 {
+			if !allowRoom(entry.rooms, room) {
+				return "", fmt.Errorf("Token not allow room: %s", room)
+			}
+			return entry.name, nil
+		}
+	}
+	return "", errors.New("Token invalid")
+}
+
+// hmacAuth HMAC signed expiring URL
+//
+//	ws://localhost:8080/{room}?name={name}&expires={unix}&signature={signature}
+//	signature = hex(HMAC-SHA256(key, room + "\n" + name + "\n" + expires))
+type hmacAuth struct {
+	key []byte
+}
+
+func (a *hmacAuth) auth(r *http.Request, room string) (string, error) {
+	query := r.URL.Query()
+	name, expires := query.Get("name"), query.Get("expires")
+	if name == "" {
+		return "", errors.New("Signed URL need name")
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", err
+	}
+	if time.Now().Unix() >= unix {
+		return "", errors.New("Signed URL expired")
+	}
+
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(room + "\n" + name + "\n" + expires))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", errors.New("Signed URL signature invalid")
+	}
+	return name, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeJWT header alg is alg, sign return the signature of signing string
+func makeJWT(alg string, claims map[string]interface{}, sign func(string) []byte) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	s := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return s + "." + base64.RawURLEncoding.EncodeToString(sign(s))
+}
+
+func signHS256(secret []byte) func(string) []byte {
+	return func(s string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(s))
+		return mac.Sum(nil)
+	}
+}
+
+func signRS256(key *rsa.PrivateKey) func(string) []byte {
+	return func(s string) []byte {
+		sum := sha256.Sum256([]byte(s))
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		return signature
+	}
+}
+
+func authRequest(a authenticator, target string, token string) (string, error) {
+	r := httptest.NewRequest("GET", target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return a.auth(r, r.URL.Path)
+}
+
+func TestJWTAuth(t *testing.T) {
+	secret := []byte("secret")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs, err := newJWTAuth("HS256", append(secret, '\n'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := newJWTAuth("RS256", publicPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newJWTAuth("RS256", secret); err == nil {
+		t.Error("RS256 should need PEM key")
+	}
+	if _, err := newJWTAuth("none", secret); err == nil {
+		t.Error("Should unknown alg")
+	}
+
+	now := time.Now().Unix()
+	claims := func(kv ...interface{}) map[string]interface{} {
+		m := map[string]interface{}{"name": "alice", "rooms": []string{"/a"}}
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i].(string)] = kv[i+1]
+		}
+		return m
+	}
+
+	for _, c := range []struct {
+		desc  string
+		auth  authenticator
+		room  string
+		token string
+		name  string
+	}{
+		{"HS256", hs, "/a", makeJWT("HS256", claims("exp", now+60, "nbf", now-60), signHS256(secret)), "alice"},
+		{"RS256", rs, "/a", makeJWT("RS256", claims(), signRS256(key)), "alice"},
+		{"sub as name", hs, "/a", makeJWT("HS256", claims("name", ""), signHS256(secret)), ""},
+		{"sub as name", hs, "/a", makeJWT("HS256", claims("name", "", "sub", "bob"), signHS256(secret)), "bob"},
+		{"empty rooms allow all", hs, "/b", makeJWT("HS256", claims("rooms", nil), signHS256(secret)), "alice"},
+		{"* allow all", hs, "/b", makeJWT("HS256", claims("rooms", []string{"*"}), signHS256(secret)), "alice"},
+
+		{"bad HS256 signature", hs, "/a", makeJWT("HS256", claims(), signHS256([]byte("other"))), ""},
+		{"bad RS256 signature", rs, "/a", makeJWT("RS256", claims(), signRS256(other)), ""},
+		{"alg confusion", rs, "/a", makeJWT("HS256", claims(), signHS256(publicPEM)), ""},
+		{"alg none", hs, "/a", makeJWT("none", claims(), func(string) []byte { return nil }), ""},
+		{"RS256 to HS256", hs, "/a", makeJWT("RS256", claims(), signRS256(key)), ""},
+		{"expired", hs, "/a", makeJWT("HS256", claims("exp", now-1), signHS256(secret)), ""},
+		{"not valid yet", hs, "/a", makeJWT("HS256", claims("nbf", now+60), signHS256(secret)), ""},
+		{"room denied", hs, "/b", makeJWT("HS256", claims(), signHS256(secret)), ""},
+		{"format", hs, "/a", "xxx.yyy", ""},
+		{"no token", hs, "/a", "", ""},
+	} {
+		name, err := authRequest(c.auth, c.room, c.token)
+		if c.name == "" && err == nil {
+			t.Errorf("%s: should reject, but name: %s", c.desc, name)
+		}
+		if c.name != "" && (err != nil || name != c.name) {
+			t.Errorf("%s: should name %s, but: %s %v", c.desc, c.name, name, err)
+		}
+	}
+
+	// Query token
+	token := makeJWT("HS256", claims(), signHS256(secret))
+	if name, err := authRequest(hs, "/a?token="+token, ""); err != nil || name != "alice" {
+		t.Error("Query token:", name, err)
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	a, err := newTokenAuth([]byte(`
+# comment
+abcd alice /a /b
+efgh bob
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTokenAuth([]byte("token-only")); err == nil {
+		t.Error("Should need name")
+	}
+
+	for _, c := range []struct {
+		desc  string
+		room  string
+		token string
+		name  string
+	}{
+		{"allow room", "/a", "abcd", "alice"},
+		{"allow room", "/b", "abcd", "alice"},
+		{"empty rooms allow all", "/c", "efgh", "bob"},
+		{"room denied", "/c", "abcd", ""},
+		{"prefix", "/a", "abc", ""},
+		{"longer", "/a", "abcde", ""},
+		{"comment", "/a", "#", ""},
+		{"no token", "/a", "", ""},
+	} {
+		name, err := authRequest(a, c.room, c.token)
+		if c.name == "" && err == nil {
+			t.Errorf("%s: should reject, but name: %s", c.desc, name)
+		}
+		if c.name != "" && (err != nil || name != c.name) {
+			t.Errorf("%s: should name %s, but: %s %v", c.desc, c.name, name, err)
+		}
+	}
+}
+
+func TestTokenAuthConstantTime(t *testing.T) {
+	// Long token, compare time is larger than the request
+	token := strings.Repeat("a", 1<<14)
+	a, err := newTokenAuth([]byte(token + " alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wrong first byte and last byte take the same time, compare the whole token
+	timeOf := func(token string) time.Duration {
+		r := httptest.NewRequest("GET", "/a", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		min := time.Duration(1<<63 - 1)
+		for round := 0; round < 5; round++ {
+			start := time.Now()
+			for i := 0; i < 200; i++ {
+				a.auth(r, "/a")
+			}
+			if d := time.Since(start); d < min {
+				min = d
+			}
+		}
+		return min
+	}
+	first, last := timeOf("b"+token[1:]), timeOf(token[1:]+"b")
+	if first*2 < last || last*2 < first {
+		t.Error("Should compare constant time:", first, last)
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	key := []byte("key")
+	sign := func(room, name, expires string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(room + "\n" + name + "\n" + expires))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	valid := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	expired := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+	a := &hmacAuth{key: key}
+
+	for _, c := range []struct {
+		desc   string
+		target string
+		name   string
+	}{
+		{"valid", "/a?name=alice&expires=" + valid + "&signature=" + sign("/a", "alice", valid), "alice"},
+		{"expired", "/a?name=alice&expires=" + expired + "&signature=" + sign("/a", "alice", expired), ""},
+		{"other room", "/b?name=alice&expires=" + valid + "&signature=" + sign("/a", "alice", valid), ""},
+		{"other name", "/a?name=bob&expires=" + valid + "&signature=" + sign("/a", "alice", valid), ""},
+		{"bad signature", "/a?name=alice&expires=" + valid + "&signature=00", ""},
+		{"no name", "/a?expires=" + valid + "&signature=" + sign("/a", "", valid), ""},
+		{"no expires", "/a?name=alice&signature=" + sign("/a", "alice", ""), ""},
+	} {
+		name, err := authRequest(a, c.target, "")
+		if c.name == "" && err == nil {
+			t.Errorf("%s: should reject, but name: %s", c.desc, name)
+		}
+		if c.name != "" && (err != nil || name != c.name) {
+			t.Errorf("%s: should name %s, but: %s %v", c.desc, c.name, name, err)
+		}
+	}
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	if a, err := newAuthenticator("none", "", ""); a != nil || err != nil {
+		t.Error("none should no auth:", a, err)
+	}
+
+	dir, err := ioutil.TempDir("", "lightcable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(file, []byte("abcd alice\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newAuthenticator("token", filepath.Join(dir, "none"), ""); err == nil {
+		t.Error("Should no key file")
+	}
+	if _, err := newAuthenticator("xxx", file, ""); err == nil {
+		t.Error("Should unknown mode")
+	}
+	for _, mode := range []string{"jwt", "token", "hmac"} {
+		if a, err := newAuthenticator(mode, file, "HS256"); a == nil || err != nil {
+			t.Errorf("%s: %v", mode, err)
+		}
+	}
+}
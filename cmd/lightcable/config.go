@@ -0,0 +1,553 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/a-wing/lightcable"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables prefix, key "server.worker.local" => "LIGHTCABLE_SERVER_WORKER_LOCAL"
+const envPrefix = "LIGHTCABLE"
+
+// config of lightcable command, YAML or TOML file, environment variables and flags
+// Priority: flags > environment variables > file > default
+type config struct {
+	Listen  string   `key:"listen"`
+	Origins []string `key:"origins"`
+
+	Server  serverConfig  `key:"server"`
+	Room    roomConfig    `key:"room"`
+	TLS     tlsConfig     `key:"tls"`
+	API     apiConfig     `key:"api"`
+	Admin   adminConfig   `key:"admin"`
+	Auth    authConfig    `key:"auth"`
+	Webhook webhookConfig `key:"webhook"`
+	Store   storeConfig   `key:"store"`
+	Record  recordConfig  `key:"record"`
+	Metrics metricsConfig `key:"metrics"`
+	Log     logConfig     `key:"log"`
+}
+
+type serverConfig struct {
+	SignBufferCount int    `key:"sign_buffer_count"`
+	CastBufferCount int    `key:"cast_buffer_count"`
+	Shards          int    `key:"shards"`
+	Compression     bool   `key:"compression"`
+	Engine          string `key:"engine"`
+	MaxConns        int    `key:"max_conns"`
+	MaxRooms        int    `key:"max_rooms"`
+	MaxConnsPerIP   int    `key:"max_conns_per_ip"`
+	BroadcastAll    string `key:"broadcast_all_policy"`
+	Wildcard        bool   `key:"wildcard"`
+	MQTT            bool   `key:"mqtt"`
+	Codecs          bool   `key:"codecs"`
+	// Persistent rooms, exist without clients
+	Rooms  []string     `key:"rooms"`
+	Worker workerConfig `key:"worker"`
+}
+
+type workerConfig struct {
+	SignBufferCount int    `key:"sign_buffer_count"`
+	CastBufferCount int    `key:"cast_buffer_count"`
+	MaxClients      int    `key:"max_clients"`
+	Local           bool   `key:"local"`
+	WriteBatch      int    `key:"write_batch"`
+	Coalesce        bool   `key:"coalesce"`
+	Separator       string `key:"separator"`
+	PoolBuffers     bool   `key:"pool_buffers"`
+	State           bool   `key:"state"`
+	Signaling       bool   `key:"signaling"`
+	// Duration, e.g: 30s
+	Linger      string `key:"linger"`
+	MaxLifetime string `key:"max_lifetime"`
+}
+
+type roomConfig struct {
+	Header    string `key:"header"`
+	Query     string `key:"query"`
+	Prefix    string `key:"prefix"`
+	Pattern   string `key:"pattern"`
+	Clean     bool   `key:"clean"`
+	MaxLength int    `key:"max_length"`
+	Charset   string `key:"charset"`
+}
+
+type tlsConfig struct {
+	Cert       string `key:"cert"`
+	Key        string `key:"key"`
+	MinVersion string `key:"min_version"`
+
+	// mTLS client certificate CA file, empty is disable
+	ClientCA string `key:"client_ca"`
+	// require: must have client certificate; optional: verify if given
+	ClientAuth string `key:"client_auth"`
+}
+
+type apiConfig struct {
+	Listen string `key:"listen"`
+	Token  string `key:"token"`
+}
+
+type adminConfig struct {
+	Listen string `key:"listen"`
+	Token  string `key:"token"`
+}
+
+type authConfig struct {
+	Mode   string `key:"mode"`
+	Key    string `key:"key"`
+	JWTAlg string `key:"jwt_alg"`
+}
+
+type webhookConfig struct {
+	URLs    []string `key:"urls"`
+	Secret  string   `key:"secret"`
+	Connect string   `key:"connect"`
+}
+
+type storeConfig struct {
+	// Empty is disable
+	Dir           string `key:"dir"`
+	SegmentSizeMB int    `key:"segment_size_mb"`
+	MaxSizeMB     int    `key:"max_size_mb"`
+	// Duration, e.g: 168h
+	MaxAge string `key:"max_age"`
+}
+
+type recordConfig struct {
+	// Recording file, JSON Lines, append. Empty is disable
+	File string `key:"file"`
+	// Empty is all rooms
+	Rooms []string `key:"rooms"`
+}
+
+type metricsConfig struct {
+	Listen string `key:"listen"`
+}
+
+type logConfig struct {
+	Level string `key:"level"`
+	File  string `key:"file"`
+}
+
+func defaultConfig() *config {
+	return &config{
+		Listen: "0.0.0.0:8080",
+		Server: serverConfig{
+			SignBufferCount: lightcable.DefaultConfig.SignBufferCount,
+			CastBufferCount: lightcable.DefaultConfig.CastBufferCount,
+			BroadcastAll:    "drop",
+			Engine:          "goroutine",
+			Worker: workerConfig{
+				SignBufferCount: lightcable.DefaultConfig.Worker.SignBufferCount,
+				CastBufferCount: lightcable.DefaultConfig.Worker.CastBufferCount,
+				Local:           lightcable.DefaultConfig.Worker.Local,
+			},
+		},
+		TLS: tlsConfig{
+			MinVersion: "1.2",
+			ClientAuth: "require",
+		},
+		Auth: authConfig{
+			Mode:   "none",
+			JWTAlg: "HS256",
+		},
+		Log: logConfig{
+			Level: "debug",
+		},
+	}
+}
+
+// loadConfig file is empty only use environment variables
+func loadConfig(file string, environ []string) (*config, error) {
+	cfg := defaultConfig()
+	if file != "" {
+		if err := cfg.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(environ); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *config) loadFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		_, err = toml.Decode(string(data), &values)
+	default:
+		return fmt.Errorf("%s: unknown config file format: %s, need .yaml, .yml or .toml", file, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+
+	if err := setStruct(reflect.ValueOf(cfg).Elem(), "", values); err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	return nil
+}
+
+// setStruct set values to struct fields by tag "key", prefix is parent key
+func setStruct(v reflect.Value, prefix string, values map[string]interface{}) error {
+	fields := make(map[string]reflect.Value)
+	for i := 0; i < v.NumField(); i++ {
+		fields[v.Type().Field(i).Tag.Get("key")] = v.Field(i)
+	}
+
+	// Sort keys, error message is stable
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		key := prefix + k
+		field, ok := fields[k]
+		if !ok {
+			return fmt.Errorf("%s: unknown key", key)
+		}
+		if err := setValue(field, key, values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setValue(field reflect.Value, key string, value interface{}) error {
+	switch field.Kind() {
+	case reflect.Struct:
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: need a table, but: %v", key, value)
+		}
+		return setStruct(field, key+".", values)
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: need a string, but: %v", key, value)
+		}
+		field.SetString(s)
+	case reflect.Int:
+		switch n := value.(type) {
+		case int:
+			field.SetInt(int64(n))
+		case int64:
+			field.SetInt(n)
+		default:
+			return fmt.Errorf("%s: need an integer, but: %v", key, value)
+		}
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("%s: need a boolean, but: %v", key, value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: need a list of string, but: %v", key, value)
+		}
+		strs := make([]string, len(list))
+		for i, item := range list {
+			if strs[i], ok = item.(string); !ok {
+				return fmt.Errorf("%s[%d]: need a string, but: %v", key, i, item)
+			}
+		}
+		field.Set(reflect.ValueOf(strs))
+	}
+	return nil
+}
+
+// loadEnv environ is os.Environ() format: "KEY=value"
+func (cfg *config) loadEnv(environ []string) error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return setEnv(reflect.ValueOf(cfg).Elem(), "", env)
+}
+
+func setEnv(v reflect.Value, prefix string, env map[string]string) error {
+	for i := 0; i < v.NumField(); i++ {
+		key := prefix + v.Type().Field(i).Tag.Get("key")
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := setEnv(field, key+".", env); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := envName(key)
+		s, ok := env[name]
+		if !ok {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(s)
+		case reflect.Int:
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("env %s: %s: need an integer, but: %s", name, key, s)
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("env %s: %s: need a boolean, but: %s", name, key, s)
+			}
+			field.SetBool(b)
+		case reflect.Slice:
+			var strs []string
+			if s != "" {
+				strs = strings.Split(s, ",")
+			}
+			field.Set(reflect.ValueOf(strs))
+		}
+	}
+	return nil
+}
+
+func envName(key string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// validate error message is "key: reason"
+func (cfg *config) validate() error {
+	if cfg.Listen == "" {
+		return errors.New("listen: required")
+	}
+	for _, item := range []struct {
+		key string
+		n   int
+	}{
+		{"server.sign_buffer_count", cfg.Server.SignBufferCount},
+		{"server.cast_buffer_count", cfg.Server.CastBufferCount},
+		{"server.worker.sign_buffer_count", cfg.Server.Worker.SignBufferCount},
+		{"server.worker.cast_buffer_count", cfg.Server.Worker.CastBufferCount},
+	} {
+		if item.n <= 0 {
+			return fmt.Errorf("%s: need greater than 0, but: %d", item.key, item.n)
+		}
+	}
+	for _, item := range []struct {
+		key string
+		n   int
+	}{
+		{"server.shards", cfg.Server.Shards},
+		{"server.max_conns", cfg.Server.MaxConns},
+		{"server.max_rooms", cfg.Server.MaxRooms},
+		{"server.max_conns_per_ip", cfg.Server.MaxConnsPerIP},
+		{"server.worker.max_clients", cfg.Server.Worker.MaxClients},
+		{"server.worker.write_batch", cfg.Server.Worker.WriteBatch},
+		{"room.max_length", cfg.Room.MaxLength},
+		{"store.segment_size_mb", cfg.Store.SegmentSizeMB},
+		{"store.max_size_mb", cfg.Store.MaxSizeMB},
+	} {
+		if item.n < 0 {
+			return fmt.Errorf("%s: need greater than or equal 0, but: %d", item.key, item.n)
+		}
+	}
+
+	if _, ok := engines[cfg.Server.Engine]; !ok {
+		return fmt.Errorf("server.engine: need goroutine or epoll, but: %q", cfg.Server.Engine)
+	}
+	if _, ok := policies[cfg.Server.BroadcastAll]; !ok {
+		return fmt.Errorf("server.broadcast_all_policy: need drop or block, but: %q", cfg.Server.BroadcastAll)
+	}
+
+	for _, item := range []struct {
+		key string
+		d   string
+	}{
+		{"server.worker.linger", cfg.Server.Worker.Linger},
+		{"server.worker.max_lifetime", cfg.Server.Worker.MaxLifetime},
+		{"store.max_age", cfg.Store.MaxAge},
+	} {
+		if item.d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(item.d); err != nil {
+			return fmt.Errorf("%s: %s", item.key, err)
+		}
+	}
+
+	if _, err := cfg.roomRule(); err != nil {
+		return err
+	}
+
+	if err := validateTLS(cfg.TLS); err != nil {
+		return err
+	}
+
+	if cfg.Admin.Listen != "" && cfg.Admin.Token == "" {
+		return errors.New("admin.token: required when admin.listen is set")
+	}
+
+	switch cfg.Auth.Mode {
+	case "none":
+	case "jwt", "token", "hmac":
+		if cfg.Auth.Key == "" {
+			return fmt.Errorf("auth.key: required when auth.mode is %q", cfg.Auth.Mode)
+		}
+	default:
+		return fmt.Errorf("auth.mode: need none, jwt, token or hmac, but: %q", cfg.Auth.Mode)
+	}
+	if cfg.Auth.JWTAlg != "HS256" && cfg.Auth.JWTAlg != "RS256" {
+		return fmt.Errorf("auth.jwt_alg: need HS256 or RS256, but: %q", cfg.Auth.JWTAlg)
+	}
+
+	for i, u := range cfg.Webhook.URLs {
+		if err := validateURL(u); err != nil {
+			return fmt.Errorf("webhook.urls[%d]: %s", i, err)
+		}
+	}
+	if cfg.Webhook.Connect != "" {
+		if err := validateURL(cfg.Webhook.Connect); err != nil {
+			return fmt.Errorf("webhook.connect: %s", err)
+		}
+	}
+
+	if _, ok := logLevels[cfg.Log.Level]; !ok {
+		return fmt.Errorf("log.level: need debug, info or error, but: %q", cfg.Log.Level)
+	}
+	return nil
+}
+
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("need http or https url, but: %q", s)
+	}
+	return nil
+}
+
+var engines = map[string]lightcable.Engine{
+	"goroutine": lightcable.EngineGoroutine,
+	"epoll":     lightcable.EngineEpoll,
+}
+
+var policies = map[string]lightcable.Policy{
+	"drop":  lightcable.PolicyDrop,
+	"block": lightcable.PolicyBlock,
+}
+
+// serverConfig to library config
+func (cfg *config) serverConfig() *lightcable.Config {
+	// Already validate
+	linger, _ := time.ParseDuration(cfg.Server.Worker.Linger)
+	maxLifetime, _ := time.ParseDuration(cfg.Server.Worker.MaxLifetime)
+	return &lightcable.Config{
+		SignBufferCount:    cfg.Server.SignBufferCount,
+		CastBufferCount:    cfg.Server.CastBufferCount,
+		Shards:             cfg.Server.Shards,
+		Compression:        cfg.Server.Compression,
+		Engine:             engines[cfg.Server.Engine],
+		MaxConns:           cfg.Server.MaxConns,
+		MaxRooms:           cfg.Server.MaxRooms,
+		MaxConnsPerIP:      cfg.Server.MaxConnsPerIP,
+		BroadcastAllPolicy: policies[cfg.Server.BroadcastAll],
+		Origins:            cfg.Origins,
+		Wildcard:           cfg.Server.Wildcard,
+		MQTT:               cfg.Server.MQTT,
+		Codecs:             cfg.Server.Codecs,
+		Rooms:              cfg.Server.Rooms,
+		Worker: lightcable.WorkerConfig{
+			SignBufferCount: cfg.Server.Worker.SignBufferCount,
+			CastBufferCount: cfg.Server.Worker.CastBufferCount,
+			MaxClients:      cfg.Server.Worker.MaxClients,
+			Local:           cfg.Server.Worker.Local,
+			WriteBatch:      cfg.Server.Worker.WriteBatch,
+			Coalesce:        cfg.Server.Worker.Coalesce,
+			Separator:       cfg.Server.Worker.Separator,
+			PoolBuffers:     cfg.Server.Worker.PoolBuffers,
+			State:           cfg.Server.Worker.State,
+			Signaling:       cfg.Server.Worker.Signaling,
+			Linger:          linger,
+			MaxLifetime:     maxLifetime,
+		},
+	}
+}
+
+// openStore file store, store.dir is empty return nil
+func (cfg *config) openStore() (*lightcable.FileStore, error) {
+	if cfg.Store.Dir == "" {
+		return nil, nil
+	}
+	// Already validate
+	maxAge, _ := time.ParseDuration(cfg.Store.MaxAge)
+	return lightcable.NewFileStore(cfg.Store.Dir, lightcable.FileStoreOptions{
+		SegmentSize: int64(cfg.Store.SegmentSizeMB) << 20,
+		MaxSize:     int64(cfg.Store.MaxSizeMB) << 20,
+		MaxAge:      maxAge,
+	})
+}
+
+// openRecording record.file append, record.file is empty return nil
+func (cfg *config) openRecording() (*os.File, error) {
+	if cfg.Record.File == "" {
+		return nil, nil
+	}
+	return os.OpenFile(cfg.Record.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// roomRule regexp compile error is "key: reason"
+func (cfg *config) roomRule() (*lightcable.RoomRule, error) {
+	rule := &lightcable.RoomRule{
+		Header:    cfg.Room.Header,
+		Query:     cfg.Room.Query,
+		Prefix:    cfg.Room.Prefix,
+		Clean:     cfg.Room.Clean,
+		MaxLength: cfg.Room.MaxLength,
+	}
+	var err error
+	if cfg.Room.Pattern != "" {
+		if rule.Pattern, err = regexp.Compile(cfg.Room.Pattern); err != nil {
+			return nil, fmt.Errorf("room.pattern: %s", err)
+		}
+	}
+	if cfg.Room.Charset != "" {
+		if rule.Charset, err = regexp.Compile(cfg.Room.Charset); err != nil {
+			return nil, fmt.Errorf("room.charset: %s", err)
+		}
+	}
+	return rule, nil
+}
+
+// openLog file is empty use stderr
+func openLog(file string) (*os.File, error) {
+	if file == "" {
+		return os.Stderr, nil
+	}
+	return os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
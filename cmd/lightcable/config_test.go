@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, dir, name, data string) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightcable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	yamlFile := writeConfig(t, dir, "config.yaml", `
+listen: 127.0.0.1:9000
+origins: ["example.com", "*.example.com"]
+server:
+  shards: 4
+  engine: epoll
+  worker:
+    local: true
+    max_clients: 2
+    linger: 30s
+room:
+  prefix: /ws
+webhook:
+  urls:
+    - http://localhost:3000/events
+log:
+  level: info
+`)
+	tomlFile := writeConfig(t, dir, "config.toml", `
+listen = "127.0.0.1:9000"
+origins = ["example.com", "*.example.com"]
+
+[server]
+shards = 4
+engine = "epoll"
+
+[server.worker]
+local = true
+max_clients = 2
+linger = "30s"
+
+[room]
+prefix = "/ws"
+
+[webhook]
+urls = ["http://localhost:3000/events"]
+
+[log]
+level = "info"
+`)
+
+	cfg, err := loadConfig(yamlFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "127.0.0.1:9000" || len(cfg.Origins) != 2 || cfg.Server.Shards != 4 ||
+		cfg.Server.Engine != "epoll" || !cfg.Server.Worker.Local || cfg.Server.Worker.MaxClients != 2 ||
+		cfg.Room.Prefix != "/ws" || len(cfg.Webhook.URLs) != 1 || cfg.Log.Level != "info" {
+		t.Errorf("YAML: %+v", cfg)
+	}
+	// Not set keep default
+	if cfg.Server.BroadcastAll != "drop" || cfg.Auth.JWTAlg != "HS256" {
+		t.Errorf("Should default: %+v", cfg)
+	}
+
+	cfg2, err := loadConfig(tomlFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, cfg2) {
+		t.Errorf("YAML and TOML should same:\n%+v\n%+v", cfg, cfg2)
+	}
+}
+
+func TestConfigPriority(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightcable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeConfig(t, dir, "config.yml", `
+listen: file:8080
+log:
+  level: info
+server:
+  worker:
+    max_clients: 10
+`)
+	environ := []string{
+		"LIGHTCABLE_LISTEN=env:8080",
+		"LIGHTCABLE_SERVER_WORKER_MAX_CLIENTS=20",
+		"LIGHTCABLE_SERVER_WORKER_LOCAL=true",
+		"LIGHTCABLE_WEBHOOK_URLS=http://a,http://b",
+		"OTHER=xxx",
+	}
+
+	// env > file
+	cfg, err := loadConfig(file, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "env:8080" || cfg.Server.Worker.MaxClients != 20 || !cfg.Server.Worker.Local ||
+		!reflect.DeepEqual(cfg.Webhook.URLs, []string{"http://a", "http://b"}) || cfg.Log.Level != "info" {
+		t.Errorf("Env should override file: %+v", cfg)
+	}
+
+	// flags > env, not set flags not override, default value too
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	defineFlags(fs)
+	if err := fs.Parse([]string{"-l", "flag:8080", "-max-clients", "30"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = loadConfig(file, flagEnviron(fs, environ)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "flag:8080" || cfg.Server.Worker.MaxClients != 30 || !cfg.Server.Worker.Local || cfg.Log.Level != "info" {
+		t.Errorf("Flags should override env: %+v", cfg)
+	}
+}
+
+func TestConfigError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightcable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		name, data string
+		environ    []string
+		key        string
+	}{
+		// Unknown keys
+		{"config.yaml", "xxx: 1", nil, "xxx: unknown key"},
+		{"config.yaml", "server:\n  worker:\n    xxx: 1", nil, "server.worker.xxx: unknown key"},
+		{"config.toml", "[server.worker]\nxxx = 1", nil, "server.worker.xxx: unknown key"},
+		{"config.json", "{}", nil, "unknown config file format"},
+
+		// Wrong types
+		{"config.yaml", "server:\n  shards: xxx", nil, "server.shards: need an integer"},
+		{"config.yaml", "server:\n  worker:\n    local: 1", nil, "server.worker.local: need a boolean"},
+		{"config.yaml", "listen: [1]", nil, "listen: need a string"},
+		{"config.yaml", "origins: [1]", nil, "origins[0]: need a string"},
+		{"config.yaml", "server: 1", nil, "server: need a table"},
+		{"config.toml", "[server]\nshards = \"xxx\"", nil, "server.shards: need an integer"},
+		{"config.yaml", "", []string{"LIGHTCABLE_SERVER_SHARDS=xxx"}, "env LIGHTCABLE_SERVER_SHARDS: server.shards: need an integer"},
+		{"config.yaml", "", []string{"LIGHTCABLE_SERVER_WORKER_LOCAL=xxx"}, "server.worker.local: need a boolean"},
+
+		// Validation
+		{"config.yaml", "listen: ''", nil, "listen: required"},
+		{"config.yaml", "server:\n  engine: xxx", nil, "server.engine:"},
+		{"config.yaml", "server:\n  max_conns: -1", nil, "server.max_conns:"},
+		{"config.yaml", "server:\n  worker:\n    cast_buffer_count: 0", nil, "server.worker.cast_buffer_count:"},
+		{"config.yaml", "server:\n  worker:\n    linger: xxx", nil, "server.worker.linger:"},
+		{"config.yaml", "auth:\n  mode: jwt", nil, "auth.key: required"},
+		{"config.yaml", "admin:\n  listen: :8081", nil, "admin.token: required"},
+		{"config.yaml", "webhook:\n  urls: [ftp://xxx]", nil, "webhook.urls[0]:"},
+		{"config.yaml", "log:\n  level: xxx", nil, "log.level:"},
+	} {
+		file := writeConfig(t, dir, c.name, c.data)
+		cfg, err := loadConfig(file, c.environ)
+		if err == nil {
+			err = cfg.validate()
+		}
+		if err == nil || !strings.Contains(err.Error(), c.key) {
+			t.Errorf("%q should error %q, but: %v", c.data, c.key, err)
+		}
+	}
+}
+
+func TestNeedRestart(t *testing.T) {
+	old := defaultConfig()
+
+	// Hot reload
+	cfg := defaultConfig()
+	cfg.Log.Level = "error"
+	cfg.Room.Prefix = "/ws"
+	cfg.Auth.Mode = "token"
+	cfg.Webhook.URLs = []string{"http://localhost"}
+	if keys := needRestart(old, cfg); len(keys) != 0 {
+		t.Error("Should hot reload:", keys)
+	}
+
+	cfg.Listen = ":9000"
+	cfg.Server.Worker.Local = !old.Server.Worker.Local
+	cfg.Record.Rooms = []string{"/xxx"}
+	cfg.Store.Dir = "data"
+	if keys := needRestart(old, cfg); strings.Join(keys, ",") != "listen,record,server,store" {
+		t.Error("Should need restart:", keys)
+	}
+}
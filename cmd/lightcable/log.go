@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+const (
+	levelDebug int32 = iota
+	levelInfo
+	levelError
+)
+
+var logLevels = map[string]int32{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"error": levelError,
+}
+
+var logLevel = levelDebug
+
+func setLogLevel(level string) {
+	atomic.StoreInt32(&logLevel, logLevels[level])
+}
+
+// debugf every message log
+func debugf(format string, v ...interface{}) {
+	if atomic.LoadInt32(&logLevel) <= levelDebug {
+		log.Printf(format, v...)
+	}
+}
+
+// infof room and connection lifecycle log
+func infof(format string, v ...interface{}) {
+	if atomic.LoadInt32(&logLevel) <= levelInfo {
+		log.Printf(format, v...)
+	}
+}
+
+// errorf reject and failed log
+func errorf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
@@ -2,15 +2,93 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/a-wing/lightcable"
 )
 
+// flags override config key
+var flagKeys = []struct {
+	name, key, value, usage string
+}{
+	{"l", "listen", "0.0.0.0:8080", "set server listen address and port"},
+	{"api", "api.listen", "", "set http publish api listen address and port, empty is disable"},
+	{"api-token", "api.token", "", "http publish api 'Authorization: Bearer <token>', empty is no auth"},
+	{"admin", "admin.listen", "", "set http admin api listen address and port, empty is disable"},
+	{"admin-token", "admin.token", "", "http admin api 'Authorization: Bearer <token>', must set when admin api enabled"},
+	{"auth", "auth.mode", "none", "websocket connection auth mode: none, jwt, token, hmac"},
+	{"auth-key", "auth.key", "", "auth key file. jwt: HS256 secret or RS256 PEM public key; token: static token file; hmac: secret"},
+	{"jwt-alg", "auth.jwt_alg", "HS256", "jwt auth algorithm: HS256, RS256"},
+	{"webhook", "webhook.urls", "", "lifecycle events webhook urls, comma separated"},
+	{"webhook-secret", "webhook.secret", "", "webhook HMAC-SHA256 signature secret, header 'X-Lightcable-Signature: sha256=<hex>'"},
+	{"webhook-connect", "webhook.connect", "", "synchronous webhook url, approve websocket connection and assign room, name"},
+	{"max-clients", "server.worker.max_clients", "0", "max clients per room, 0 is unlimited, e.g: 2 is 1:1 call"},
+	{"signaling", "server.worker.signaling", "false", "WebRTC signaling helper mode: true, false"},
+	{"room-prefix", "room.prefix", "", "strip URL path prefix as room, e.g: '/ws' => '/ws/xxx' room is '/xxx'"},
+	{"tls-cert", "tls.cert", "", "TLS certificate file"},
+	{"tls-key", "tls.key", "", "TLS private key file"},
+	{"tls-min-version", "tls.min_version", "1.2", "TLS minimum version: 1.0, 1.1, 1.2, 1.3"},
+	{"tls-client-ca", "tls.client_ca", "", "mTLS client certificate CA file, client certificate subject common name is default client name"},
+	{"record", "record.file", "", "record rooms traffic to file, JSON Lines, replay by 'lightcable replay', empty is disable"},
+	{"record-rooms", "record.rooms", "", "record rooms, comma separated, empty is all rooms"},
+	{"metrics", "metrics.listen", "", "set prometheus metrics listen address and port, empty is disable"},
+	{"log-level", "log.level", "debug", "log level: debug, info, error"},
+}
+
+func defineFlags(fs *flag.FlagSet) {
+	for _, f := range flagKeys {
+		fs.String(f.name, f.value, f.usage+", config key: "+f.key)
+	}
+}
+
+// flagEnviron flags set as the last environment variables, override others
+func flagEnviron(fs *flag.FlagSet, environ []string) []string {
+	fs.Visit(func(f *flag.Flag) {
+		for _, k := range flagKeys {
+			if k.name == f.Name {
+				environ = append(environ, envName(k.key)+"="+f.Value.String())
+			}
+		}
+	})
+	return environ
+}
+
+// state can hot reload by SIGHUP
+type state struct {
+	cfg  *config
+	room *lightcable.RoomRule
+	auth authenticator
+}
+
+type app struct {
+	file    string
+	environ []string
+
+	state   atomic.Value
+	logFile *os.File
+
+	server  *lightcable.Server
+	hook    *webhook
+	metrics *metrics
+}
+
 func main() {
-	address := flag.String("l", "0.0.0.0:8080", "set server listen address and port")
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(os.Args[2:])
+		return
+	}
+
+	file := flag.String("c", "", "config file, .yaml .yml or .toml. Environment variables: LIGHTCABLE_<KEY>, e.g: LIGHTCABLE_SERVER_WORKER_LOCAL=true")
+	defineFlags(flag.CommandLine)
 	help := flag.Bool("h", false, "this help")
 	flag.Parse()
 
@@ -19,24 +97,217 @@ func main() {
 		return
 	}
 
-	server := lightcable.New(lightcable.DefaultConfig)
-	server.OnRoomReady(func(room string) {
-		log.Printf("Room Ready: %s", room)
+	a := &app{
+		file:    *file,
+		environ: flagEnviron(flag.CommandLine, os.Environ()),
+	}
+	st, err := a.load()
+	if err != nil {
+		log.Fatal("config: ", err)
+	}
+	a.apply(st)
+	cfg := st.cfg
+
+	a.hook = newWebhook(cfg.Webhook.URLs, cfg.Webhook.Secret)
+	go a.hook.run()
+
+	serverConfig := cfg.serverConfig()
+	store, err := cfg.openStore()
+	if err != nil {
+		log.Fatal("store: ", err)
+	}
+	if store != nil {
+		serverConfig.Store = store
+	}
+	a.server = lightcable.New(serverConfig)
+	recording, err := cfg.openRecording()
+	if err != nil {
+		log.Fatal("record: ", err)
+	}
+	if recording != nil {
+		recorder := lightcable.NewRecorder(recording)
+		for _, room := range cfg.Record.Rooms {
+			a.server.Record(room, recorder)
+		}
+		// Empty is all rooms
+		if len(cfg.Record.Rooms) == 0 {
+			a.server.RecordAll(recorder)
+		}
+	}
+	a.metrics = &metrics{server: a.server}
+	a.server.OnConnect(a.onConnect)
+	a.server.OnReject(func(r *http.Request, rejection *lightcable.Rejection) {
+		errorf("Reject: %s, %s, %d %s", r.URL, r.RemoteAddr, rejection.Code, rejection)
 	})
-	server.OnRoomClose(func(room string) {
-		log.Printf("Room Close: %s", room)
+	a.server.OnRoomReady(func(room string) {
+		infof("Room Ready: %s", room)
+		a.hook.emit("room_ready", room, "")
 	})
-	server.OnConnReady(func(c *lightcable.Client) {
-		log.Printf("Room: %s, Conn Ready: %s", c.Room, c.Name)
+	a.server.OnRoomClose(func(room string) {
+		infof("Room Close: %s", room)
+		a.hook.emit("room_close", room, "")
 	})
-	server.OnConnClose(func(c *lightcable.Client) {
-		log.Printf("Room: %s, Conn Close: %s", c.Room, c.Name)
+	a.server.OnConnReady(func(c *lightcable.Client) {
+		infof("Room: %s, Conn Ready: %s", c.Room, c.Name)
+		a.hook.emit("conn_ready", c.Room, c.Name)
+		a.metrics.onConnReady()
 	})
-	server.OnMessage(func(m *lightcable.Message) {
-		log.Printf("Room: %s, Conn: %s, Data: %s, Code: %d", m.Room, m.Name, m.Data, m.Code)
+	a.server.OnConnClose(func(c *lightcable.Client) {
+		infof("Room: %s, Conn Close: %s", c.Room, c.Name)
+		a.hook.emit("conn_close", c.Room, c.Name)
 	})
-	go server.Run(context.Background())
+	a.server.OnMessage(func(m *lightcable.Message) {
+		debugf("Room: %s, Conn: %s, Data: %s, Code: %d", m.Room, m.Name, m.Data, m.Code)
+		a.metrics.onMessage()
+	})
+	go a.server.Run(context.Background())
+
+	go a.watchReload()
+
+	if cfg.API.Listen != "" {
+		publisher := lightcable.NewPublisher(a.server)
+		if cfg.API.Token != "" {
+			publisher.OnAuth(func(r *http.Request) (string, bool) {
+				auth := []byte(r.Header.Get("Authorization"))
+				return "http", subtle.ConstantTimeCompare(auth, []byte("Bearer "+cfg.API.Token)) == 1
+			})
+		}
+		go func() {
+			log.Println("Publish api listen address:", cfg.API.Listen)
+			log.Fatal(http.ListenAndServe(cfg.API.Listen, publisher))
+		}()
+	}
+
+	if cfg.Admin.Listen != "" {
+		go func() {
+			log.Println("Admin api listen address:", cfg.Admin.Listen)
+			log.Fatal(http.ListenAndServe(cfg.Admin.Listen, lightcable.NewAdmin(a.server, cfg.Admin.Token)))
+		}()
+	}
+
+	if cfg.Metrics.Listen != "" {
+		go func() {
+			log.Println("Metrics listen address:", cfg.Metrics.Listen)
+			log.Fatal(http.ListenAndServe(cfg.Metrics.Listen, a.metrics))
+		}()
+	}
+
+	log.Println("Listen address:", cfg.Listen)
+	if cfg.TLS.Cert != "" {
+		tlsConfig, err := newTLSConfig(cfg.TLS)
+		if err != nil {
+			log.Fatal("tls: ", err)
+		}
+		server := &http.Server{
+			Addr:      cfg.Listen,
+			Handler:   a.server,
+			TLSConfig: tlsConfig,
+		}
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+	log.Fatal(http.ListenAndServe(cfg.Listen, a.server))
+}
+
+// load config and create authenticator
+func (a *app) load() (*state, error) {
+	cfg, err := loadConfig(a.file, a.environ)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	room, err := cfg.roomRule()
+	if err != nil {
+		return nil, err
+	}
+	auth, err := newAuthenticator(cfg.Auth.Mode, cfg.Auth.Key, cfg.Auth.JWTAlg)
+	if err != nil {
+		return nil, err
+	}
+	return &state{cfg: cfg, room: room, auth: auth}, nil
+}
 
-	log.Println("Listen address:", *address)
-	log.Fatal(http.ListenAndServe(*address, server))
+// apply hot reload safe settings: room, log, auth, webhook
+func (a *app) apply(st *state) {
+	if f, err := openLog(st.cfg.Log.File); err != nil {
+		errorf("Open log file: %s", err)
+	} else {
+		log.SetOutput(f)
+		if a.logFile != nil && a.logFile != os.Stderr {
+			a.logFile.Close()
+		}
+		a.logFile = f
+	}
+	setLogLevel(st.cfg.Log.Level)
+	if a.hook != nil {
+		a.hook.set(st.cfg.Webhook.URLs, st.cfg.Webhook.Secret)
+	}
+	a.state.Store(st)
+}
+
+func (a *app) watchReload() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		st, err := a.load()
+		if err != nil {
+			errorf("Reload config: %s", err)
+			continue
+		}
+		for _, key := range needRestart(a.state.Load().(*state).cfg, st.cfg) {
+			errorf("Reload config: %s changed, need restart", key)
+		}
+		a.apply(st)
+		log.Println("Reload config done")
+	}
+}
+
+// needRestart changed keys can't hot reload, sorted
+func needRestart(old, cfg *config) []string {
+	var keys []string
+	for key, changed := range map[string]bool{
+		"listen":  old.Listen != cfg.Listen,
+		"origins": !reflect.DeepEqual(old.Origins, cfg.Origins),
+		"server":  !reflect.DeepEqual(old.Server, cfg.Server),
+		"tls":     old.TLS != cfg.TLS,
+		"api":     old.API != cfg.API,
+		"admin":   old.Admin != cfg.Admin,
+		"metrics": old.Metrics != cfg.Metrics,
+		"store":   old.Store != cfg.Store,
+		"record":  !reflect.DeepEqual(old.Record, cfg.Record),
+	} {
+		if changed {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (a *app) onConnect(r *http.Request) (string, string, error) {
+	st := a.state.Load().(*state)
+	room, err := st.room.Room(r)
+	if err != nil {
+		e := err.(*lightcable.RoomError)
+		return "", "", &lightcable.Rejection{Code: e.Code, Body: e.Reason}
+	}
+	// Empty name, server assign a unique ID
+	name := ""
+	if cert := clientCert(r); cert != nil && cert.Subject.CommonName != "" {
+		name = cert.Subject.CommonName
+	}
+	if st.auth != nil {
+		if name, err = st.auth.auth(r, room); err != nil {
+			errorf("Room: %s, Auth Reject: %s, %s", room, r.RemoteAddr, err)
+			return "", "", &lightcable.Rejection{Code: http.StatusUnauthorized}
+		}
+	}
+	if st.cfg.Webhook.Connect != "" {
+		var code int
+		if room, name, code = a.hook.connect(st.cfg.Webhook.Connect, r, room, name); code != 0 {
+			return "", "", &lightcable.Rejection{Code: code}
+		}
+	}
+	return room, name, nil
 }
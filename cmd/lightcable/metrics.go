@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/a-wing/lightcable"
+)
+
+// metrics Prometheus text format, GET /metrics
+type metrics struct {
+	// OnMessage and OnConnReady totals, atomic add from worker goroutines, uint64 first as 32-bit ARM need
+	messages    uint64
+	connections uint64
+
+	server *lightcable.Server
+}
+
+func (m *metrics) onMessage() {
+	atomic.AddUint64(&m.messages, 1)
+}
+
+func (m *metrics) onConnReady() {
+	atomic.AddUint64(&m.connections, 1)
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+
+	stats := m.server.Stats()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "lightcable_rooms", "gauge", "Current rooms count.", uint64(stats.Rooms))
+	writeMetric(w, "lightcable_clients", "gauge", "Current websocket connections count.", uint64(stats.Conns))
+	writeMetric(w, "lightcable_remote_ips", "gauge", "Current websocket connections remote IPs count.", uint64(stats.IPs))
+	writeMetric(w, "lightcable_accepted_total", "counter", "Total accepted websocket connections.", stats.Accepted)
+	writeMetric(w, "lightcable_rejected_total", "counter", "Total rejected websocket connections by limits.", stats.Rejected)
+	writeMetric(w, "lightcable_dropped_total", "counter", "Total server broadcast messages dropped by busy room.", stats.Dropped)
+	writeMetric(w, "lightcable_store_errors_total", "counter", "Total store append failed messages.", stats.StoreErrors)
+	writeMetric(w, "lightcable_connections_total", "counter", "Total joined room websocket connections.", atomic.LoadUint64(&m.connections))
+	writeMetric(w, "lightcable_messages_total", "counter", "Total received websocket messages.", atomic.LoadUint64(&m.messages))
+}
+
+func writeMetric(w http.ResponseWriter, name, typ, help string, value uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, typ, name, value)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/a-wing/lightcable"
+	"github.com/gorilla/websocket"
+)
+
+// replay subcommand, play a recording back into a running server
+// Every recorded room is a websocket client, as a virtual sender
+// The server decide this client name, the recorded sender name is lost
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: lightcable replay [flags] <recording file>")
+		fs.PrintDefaults()
+	}
+	url := fs.String("url", "ws://localhost:8080", "lightcable server websocket url, recorded room append to it, e.g: ws://localhost:8080/ws")
+	speed := fs.Float64("speed", 1, "replay speed, 1 is original pace, 2 is double, 0 is no wait")
+	room := fs.String("room", "", "only replay the room, empty is all rooms")
+	token := fs.String("token", "", "auth token, header 'Authorization: Bearer <token>'")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *speed < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal("replay: ", err)
+	}
+	defer f.Close()
+
+	header := http.Header{}
+	if *token != "" {
+		header.Set("Authorization", "Bearer "+*token)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	conns := make(map[string]*websocket.Conn)
+	defer func() {
+		for _, conn := range conns {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			conn.Close()
+		}
+	}()
+
+	count := 0
+	err = lightcable.Replay(ctx, f, *speed, func(r *lightcable.Record) error {
+		if *room != "" && r.Room != *room {
+			return nil
+		}
+		conn, ok := conns[r.Room]
+		if !ok {
+			var err error
+			if conn, _, err = websocket.DefaultDialer.DialContext(ctx, *url+r.Room, header); err != nil {
+				return fmt.Errorf("room %s: %s", r.Room, err)
+			}
+			conns[r.Room] = conn
+			// Discard room messages, handle ping and close
+			go func() {
+				for {
+					if _, _, err := conn.NextReader(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+		count++
+		return conn.WriteMessage(r.Code, r.Data)
+	})
+	log.Printf("Replay %d messages", count)
+	if err != nil && err != context.Canceled {
+		log.Fatal("replay: ", err)
+	}
+}
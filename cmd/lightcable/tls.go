@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Certificate files check modify time interval
+const certCheckInterval = 10 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader reload certificate and key files when them changed
+// No need restart when renew certificate
+type certReloader struct {
+	certFile, keyFile string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	modTime, err := c.lastModTime()
+	if err != nil {
+		return err
+	}
+	c.cert, c.modTime = &cert, modTime
+	return nil
+}
+
+func (c *certReloader) lastModTime() (time.Time, error) {
+	var last time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return last, err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
+
+// GetCertificate is tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now := time.Now(); now.Sub(c.checked) > certCheckInterval {
+		c.checked = now
+		if modTime, err := c.lastModTime(); err == nil && !modTime.Equal(c.modTime) {
+			// Maybe certificate and key files are writing, use old certificate
+			if err := c.load(); err != nil {
+				errorf("Reload TLS certificate: %s", err)
+			} else {
+				infof("Reload TLS certificate: %s", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+func newTLSConfig(cfg tlsConfig) (*tls.Config, error) {
+	reloader, err := newCertReloader(cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tlsVersions[cfg.MinVersion],
+	}
+
+	if cfg.ClientCA != "" {
+		data, err := ioutil.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no PEM certificate", cfg.ClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if cfg.ClientAuth == "optional" {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return tlsConfig, nil
+}
+
+func validateTLS(cfg tlsConfig) error {
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		return errors.New("tls.cert, tls.key: need set both")
+	}
+	if _, ok := tlsVersions[cfg.MinVersion]; !ok {
+		return fmt.Errorf("tls.min_version: need 1.0, 1.1, 1.2 or 1.3, but: %q", cfg.MinVersion)
+	}
+	if cfg.ClientCA != "" && cfg.Cert == "" {
+		return errors.New("tls.client_ca: need set tls.cert and tls.key")
+	}
+	if cfg.ClientAuth != "require" && cfg.ClientAuth != "optional" {
+		return fmt.Errorf("tls.client_auth: need require or optional, but: %q", cfg.ClientAuth)
+	}
+	return nil
+}
+
+// clientCert mTLS verified client certificate, nil is no client certificate
+func clientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/a-wing/lightcable"
+)
+
+const (
+	// Webhook batch max events count
+	webhookBatchSize = 100
+
+	// Webhook batch max wait time
+	webhookBatchWait = time.Second
+
+	// Webhook event buffer count, full will drop event
+	webhookBufferCount = 4096
+
+	// Webhook failed retry times, backoff: 1s, 2s, 4s...
+	webhookRetry = 3
+
+	// Webhook per url pending batches, full will drop batch
+	webhookQueueCount = 64
+
+	// Webhook HTTP request timeout
+	webhookTimeout = 5 * time.Second
+
+	// HMAC-SHA256 signature of request body
+	webhookSignatureHeader = "X-Lightcable-Signature"
+)
+
+// webhookEvent lifecycle event
+type webhookEvent struct {
+	Event string    `json:"event"`
+	Room  string    `json:"room"`
+	Name  string    `json:"name,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// webhook dispatcher POST JSON events batch to urls
+//
+//	[{"event": "conn_ready", "room": "/xxx", "name": "xxx", "time": "2006-01-02T15:04:05Z"}]
+type webhook struct {
+	mutex  sync.RWMutex
+	urls   []string
+	secret []byte
+
+	client *http.Client
+	events chan webhookEvent
+
+	// Per url retry queue, slow url not block others. Only run goroutine access it
+	queues map[string]chan []byte
+}
+
+// webhookHeaders connect webhook forward these request headers only
+// Authorization, Cookie and others credentials never leave this server
+var webhookHeaders = []string{
+	"Origin",
+	"User-Agent",
+	"Referer",
+	"Accept-Language",
+	"X-Forwarded-For",
+	"X-Real-Ip",
+}
+
+// webhookQueryCredentials connect webhook url remove these query, token and signed URL
+var webhookQueryCredentials = []string{
+	"token",
+	"signature",
+	"expires",
+}
+
+// webhookURL request url without credentials query
+func webhookURL(u *url.URL) string {
+	query := u.Query()
+	for _, key := range webhookQueryCredentials {
+		query.Del(key)
+	}
+	clean := url.URL{Path: u.Path, RawQuery: query.Encode()}
+	return clean.String()
+}
+
+func newWebhook(urls []string, secret string) *webhook {
+	h := &webhook{
+		client: &http.Client{Timeout: webhookTimeout},
+		events: make(chan webhookEvent, webhookBufferCount),
+		queues: make(map[string]chan []byte),
+	}
+	h.set(urls, secret)
+	return h
+}
+
+// set urls and secret, Hot reload
+func (h *webhook) set(urls []string, secret string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.urls = urls
+	h.secret = []byte(secret)
+}
+
+func (h *webhook) config() ([]string, []byte) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.urls, h.secret
+}
+
+// emit never block server, no urls will ignore
+func (h *webhook) emit(event, room, name string) {
+	if urls, _ := h.config(); len(urls) == 0 {
+		return
+	}
+	select {
+	case h.events <- webhookEvent{
+		Event: event,
+		Room:  room,
+		Name:  name,
+		Time:  time.Now(),
+	}:
+	default:
+		errorf("Webhook buffer full, drop event: %s, %s", event, room)
+	}
+}
+
+func (h *webhook) run() {
+	batch := make([]webhookEvent, 0, webhookBatchSize)
+	timer := time.NewTimer(webhookBatchWait)
+	for {
+		select {
+		case event := <-h.events:
+			batch = append(batch, event)
+			if len(batch) < webhookBatchSize {
+				continue
+			}
+		case <-timer.C:
+		}
+
+		if len(batch) != 0 {
+			h.send(batch)
+			batch = batch[:0]
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(webhookBatchWait)
+	}
+}
+
+// send the batch to every url queue, full queue drop it
+func (h *webhook) send(batch []webhookEvent) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		errorf("Webhook: %s", err)
+		return
+	}
+	urls, _ := h.config()
+	h.update(urls)
+	for _, url := range urls {
+		select {
+		case h.queues[url] <- body:
+		default:
+			errorf("Webhook: %s, queue full, drop %d events", url, len(batch))
+		}
+	}
+}
+
+// update url queues by hot reload urls, removed url queue closed
+func (h *webhook) update(urls []string) {
+	exist := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		exist[url] = true
+		if _, ok := h.queues[url]; !ok {
+			queue := make(chan []byte, webhookQueueCount)
+			h.queues[url] = queue
+			go h.deliver(url, queue)
+		}
+	}
+	for url, queue := range h.queues {
+		if !exist[url] {
+			close(queue)
+			delete(h.queues, url)
+		}
+	}
+}
+
+// deliver the url queue batches in order, failed retry with backoff
+func (h *webhook) deliver(url string, queue chan []byte) {
+	for body := range queue {
+		backoff := time.Second
+		for i := 0; ; i++ {
+			err := h.post(url, body, nil)
+			if err == nil {
+				break
+			}
+			if i >= webhookRetry {
+				errorf("Webhook: %s, drop events: %s", url, err)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (h *webhook) post(url string, body []byte, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if _, secret := h.config(); len(secret) != 0 {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		io.Copy(ioutil.Discard, res.Body)
+		return &webhookError{StatusCode: res.StatusCode}
+	}
+	if v != nil {
+		// Empty body is allowed
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return nil
+}
+
+type webhookError struct {
+	StatusCode int
+}
+
+func (e *webhookError) Error() string {
+	return fmt.Sprintf("Webhook response status: %d", e.StatusCode)
+}
+
+// connectRequest synchronous webhook approve websocket connection request
+type connectRequest struct {
+	Room       string      `json:"room"`
+	Name       string      `json:"name,omitempty"`
+	URL        string      `json:"url"`
+	RemoteAddr string      `json:"remote_addr"`
+	Header     http.Header `json:"header"`
+
+	// mTLS verified client certificate subject
+	TLSSubject string `json:"tls_subject,omitempty"`
+}
+
+// connectResponse 2xx approve, others reject
+// room, name is empty will not change
+// perm: "publish", "subscribe", default all
+type connectResponse struct {
+	Room string `json:"room"`
+	Name string `json:"name"`
+	Perm string `json:"perm"`
+}
+
+// connect synchronous webhook, external service approve this connection and assign room, name
+// return error status code
+func (h *webhook) connect(url string, r *http.Request, room, name string) (string, string, int) {
+	req := connectRequest{
+		Room:       room,
+		Name:       name,
+		URL:        webhookURL(r.URL),
+		RemoteAddr: r.RemoteAddr,
+		Header:     http.Header{},
+	}
+	for _, key := range webhookHeaders {
+		if values := r.Header[key]; len(values) != 0 {
+			req.Header[key] = values
+		}
+	}
+	if cert := clientCert(r); cert != nil {
+		req.TLSSubject = cert.Subject.String()
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", "", http.StatusInternalServerError
+	}
+
+	var res connectResponse
+	if err := h.post(url, body, &res); err != nil {
+		errorf("Room: %s, Connect Webhook Reject: %s, %s", room, r.RemoteAddr, err)
+		var e *webhookError
+		if errors.As(err, &e) && e.StatusCode/100 == 4 {
+			return "", "", e.StatusCode
+		}
+		return "", "", http.StatusServiceUnavailable
+	}
+
+	if res.Room != "" {
+		room = res.Room
+	}
+	if res.Name != "" {
+		name = res.Name
+	}
+	switch res.Perm {
+	case "publish":
+		lightcable.SetPermission(r, lightcable.PermPublish)
+	case "subscribe":
+		lightcable.SetPermission(r, lightcable.PermSubscribe)
+	}
+	return room, name, 0
+}
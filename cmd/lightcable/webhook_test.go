@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookConnect(t *testing.T) {
+	var req connectRequest
+	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(`{"name": "bob"}`))
+	}))
+	defer hookServer.Close()
+
+	hook := newWebhook(nil, "")
+	r := httptest.NewRequest("GET", "/a?token=xxx&name=alice&expires=1&signature=yyy", nil)
+	r.Header.Set("Authorization", "Bearer xxx")
+	r.Header.Set("Cookie", "session=xxx")
+	r.Header.Set("Origin", "http://localhost")
+
+	room, name, code := hook.connect(hookServer.URL, r, "/a", "alice")
+	if room != "/a" || name != "bob" || code != 0 {
+		t.Error("Connect:", room, name, code)
+	}
+
+	// Credentials never leave this server
+	if req.URL != "/a?name=alice" {
+		t.Error("URL should without credentials:", req.URL)
+	}
+	if len(req.Header) != 1 || req.Header.Get("Origin") != "http://localhost" {
+		t.Error("Header should allowlisted only:", req.Header)
+	}
+}
@@ -0,0 +1,173 @@
+package lightcable
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// Codec websocket subprotocols, need Config.Codecs
+//
+// Codec client send and recv binary messages, the value is typed payload:
+//
+//	string value     <=> text message
+//	binary value     <=> binary message
+//	others value     <=> text message is JSON, e.g: map <=> {"type": "lightcable.state.set", ...}
+//
+// Text message is valid JSON, the codec client recv the JSON value
+const (
+	SubprotocolMsgpack = "lightcable.msgpack"
+	SubprotocolCBOR    = "lightcable.cbor"
+)
+
+// Max nesting depth of decoding value
+const maxCodecDepth = 64
+
+var (
+	errCodecSyntax   = errors.New("Codec Syntax Error")
+	errCodecType     = errors.New("Codec Unsupported Type")
+	errCodecMapKey   = errors.New("Codec Map Key Need String")
+	errCodecMaxDepth = errors.New("Codec Exceeded Max Depth")
+)
+
+// codec encode and decode a value
+// value is nil, bool, int64, uint64, float64, json.Number, string, []byte, []interface{}, map[string]interface{}
+type codec interface {
+	// index of codecs, cache the encoded frame
+	index() int
+	encode(b []byte, v interface{}) ([]byte, error)
+	decode(data []byte) (interface{}, error)
+}
+
+var codecs = []codec{msgpackCodec{}, cborCodec{}}
+
+// codecOf negotiated subprotocol, nil is no codec
+func codecOf(subprotocol string) codec {
+	switch subprotocol {
+	case SubprotocolMsgpack:
+		return codecs[0]
+	case SubprotocolCBOR:
+		return codecs[1]
+	}
+	return nil
+}
+
+// encodeMessage websocket message to the codec binary
+func encodeMessage(c codec, code int, data []byte) ([]byte, error) {
+	if code == websocket.BinaryMessage {
+		return c.encode(nil, data)
+	}
+	if json.Valid(data) {
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&v); err == nil {
+			return c.encode(nil, v)
+		}
+	}
+	return c.encode(nil, string(data))
+}
+
+// decodeMessage the codec binary to websocket message
+func decodeMessage(c codec, data []byte) (int, []byte, error) {
+	v, err := c.decode(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	switch v := v.(type) {
+	case string:
+		return websocket.TextMessage, []byte(v), nil
+	case []byte:
+		return websocket.BinaryMessage, v, nil
+	}
+	data, err = json.Marshal(v)
+	return websocket.TextMessage, data, err
+}
+
+// codecFrame the message encoded by a codec, all same codec clients share it
+type codecFrame struct {
+	data     []byte
+	prepared *websocket.PreparedMessage
+}
+
+// encode the message for the client, frames cache the encoded frame
+// No codec or encode failed return the message
+func (c *Client) encode(message Message, frames []*codecFrame) Message {
+	if c.codec == nil {
+		return message
+	}
+	if frames == nil {
+		frames = make([]*codecFrame, len(codecs))
+	}
+	i := c.codec.index()
+	if frames[i] == nil {
+		data, err := encodeMessage(c.codec, message.Code, message.Data)
+		if err != nil {
+			return message
+		}
+		frames[i] = &codecFrame{data: data}
+		frames[i].prepared, _ = websocket.NewPreparedMessage(websocket.BinaryMessage, data)
+	}
+	message.Code = websocket.BinaryMessage
+	message.Data = frames[i].data
+	message.prepared = frames[i].prepared
+	return message
+}
+
+// decode the codec client binary message to the room message
+// No codec or not binary message return it, decode failed return false
+func (c *Client) decode(code int, buf *buffer) (int, *buffer, bool) {
+	if c.codec == nil || code != websocket.BinaryMessage {
+		return code, buf, true
+	}
+	code, data, err := decodeMessage(c.codec, buf.Bytes())
+	buf.release()
+	if err != nil {
+		return 0, nil, false
+	}
+	buf = newMessageBuffer(c.worker.server.config.PoolBuffers)
+	buf.Write(data)
+	return code, buf, true
+}
+
+// codecDecoder read the data, short data set err
+type codecDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *codecDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errCodecSyntax
+		return nil
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+// uint read n bytes big endian unsigned integer
+func (d *codecDecoder) uint(n int) uint64 {
+	var u uint64
+	for _, c := range d.next(n) {
+		u = u<<8 | uint64(c)
+	}
+	return u
+}
+
+// jsonNumber to int64, uint64 or float64
+func jsonNumber(n json.Number) (interface{}, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return u, nil
+	}
+	return strconv.ParseFloat(string(n), 64)
+}
@@ -0,0 +1,138 @@
+package lightcable
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCodec(t *testing.T) {
+	for _, item := range []struct {
+		codec codec
+		// websocket message
+		code int
+		data string
+		// codec binary
+		encoded []byte
+	}{
+		{msgpackCodec{}, websocket.TextMessage, `{"a":1}`, []byte{0x81, 0xa1, 'a', 0x01}},
+		{msgpackCodec{}, websocket.TextMessage, `hi`, []byte{0xa2, 'h', 'i'}},
+		{msgpackCodec{}, websocket.BinaryMessage, "\x01\x02", []byte{0xc4, 0x02, 0x01, 0x02}},
+		{msgpackCodec{}, websocket.TextMessage, `[1,-1,true,null]`, []byte{0x94, 0x01, 0xff, 0xc3, 0xc0}},
+		{msgpackCodec{}, websocket.TextMessage, `1.5`, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{cborCodec{}, websocket.TextMessage, `{"a":1}`, []byte{0xa1, 0x61, 'a', 0x01}},
+		{cborCodec{}, websocket.TextMessage, `hi`, []byte{0x62, 'h', 'i'}},
+		{cborCodec{}, websocket.BinaryMessage, "\x01\x02", []byte{0x42, 0x01, 0x02}},
+		{cborCodec{}, websocket.TextMessage, `[1,-1,true,null]`, []byte{0x84, 0x01, 0x20, 0xf5, 0xf6}},
+		{cborCodec{}, websocket.TextMessage, `500`, []byte{0x19, 0x01, 0xf4}},
+		{cborCodec{}, websocket.TextMessage, `1.5`, []byte{0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+	} {
+		encoded, err := encodeMessage(item.codec, item.code, []byte(item.data))
+		if err != nil || !bytes.Equal(encoded, item.encoded) {
+			t.Errorf("%T encode %s: %x %v", item.codec, item.data, encoded, err)
+		}
+		code, data, err := decodeMessage(item.codec, item.encoded)
+		if err != nil || code != item.code || string(data) != item.data {
+			t.Errorf("%T decode %x: %d %s %v", item.codec, item.encoded, code, data, err)
+		}
+	}
+
+	// Others encoder
+	for _, item := range []struct {
+		codec   codec
+		encoded []byte
+		data    string
+	}{
+		{msgpackCodec{}, []byte{0xcd, 0x01, 0x2c}, `300`},
+		{msgpackCodec{}, []byte{0xd9, 0x02, 'h', 'i'}, `hi`},
+		{msgpackCodec{}, []byte{0xde, 0x00, 0x01, 0xa1, 'a', 0xc2}, `{"a":false}`},
+		{cborCodec{}, []byte{0xf9, 0x3e, 0x00}, `1.5`},
+		{cborCodec{}, []byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, `1363896240`},
+	} {
+		if _, data, err := decodeMessage(item.codec, item.encoded); err != nil || string(data) != item.data {
+			t.Errorf("%T decode %x: %s %v", item.codec, item.encoded, data, err)
+		}
+	}
+
+	// Malformed
+	for _, item := range []struct {
+		codec   codec
+		encoded []byte
+	}{
+		{msgpackCodec{}, []byte{0xa2, 'h'}},
+		{msgpackCodec{}, []byte{0x81, 0x01, 0x01}},
+		{msgpackCodec{}, []byte{0xdd, 0xff, 0xff, 0xff, 0xff}},
+		{msgpackCodec{}, []byte{0xc0, 0xc0}},
+		{cborCodec{}, []byte{0x9f, 0x01, 0xff}},
+		{cborCodec{}, []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{cborCodec{}, bytes.Repeat([]byte{0x81}, maxCodecDepth+2)},
+	} {
+		if _, _, err := decodeMessage(item.codec, item.encoded); err == nil {
+			t.Errorf("%T decode %x: should error", item.codec, item.encoded)
+		}
+	}
+}
+
+func TestServerCodecs(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Codecs = true
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	join := make(chan string, 3)
+	server.OnConnReady(func(c *Client) { join <- c.Name })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+
+	dial := func(subprotocol string) *websocket.Conn {
+		dialer := websocket.Dialer{Subprotocols: []string{subprotocol}}
+		ws, _, err := dialer.Dial(makeWsProto(httpServer.URL+"/test"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws.Subprotocol() != subprotocol {
+			t.Fatal("Subprotocol:", ws.Subprotocol())
+		}
+		<-join
+		return ws
+	}
+	ws, mp, cb := dial(""), dial(SubprotocolMsgpack), dial(SubprotocolCBOR)
+
+	expect := func(ws *websocket.Conn, code int, data []byte) {
+		if c, recv, err := ws.ReadMessage(); err != nil || c != code || !bytes.Equal(recv, data) {
+			t.Errorf("expect %d %x, but: %d %x %v", code, data, c, recv, err)
+		}
+	}
+
+	// JSON to codec clients
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"a":1}`)); err != nil {
+		t.Error(err)
+	}
+	expect(mp, websocket.BinaryMessage, []byte{0x81, 0xa1, 'a', 0x01})
+	expect(cb, websocket.BinaryMessage, []byte{0xa1, 0x61, 'a', 0x01})
+
+	// MessagePack string to others
+	if err := mp.WriteMessage(websocket.BinaryMessage, []byte{0xa2, 'h', 'i'}); err != nil {
+		t.Error(err)
+	}
+	expect(ws, websocket.TextMessage, []byte("hi"))
+	expect(cb, websocket.BinaryMessage, []byte{0x62, 'h', 'i'})
+
+	// Codec client text message not decode
+	if err := cb.WriteMessage(websocket.TextMessage, []byte(`"text"`)); err != nil {
+		t.Error(err)
+	}
+	expect(ws, websocket.TextMessage, []byte(`"text"`))
+	expect(mp, websocket.BinaryMessage, []byte{0xa4, 't', 'e', 'x', 't'})
+
+	cancel()
+	<-sign
+}
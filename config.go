@@ -1,5 +1,30 @@
 package lightcable
 
+import "time"
+
+// Policy is how to handle busy room, when broadcast message to all rooms
+type Policy int8
+
+const (
+	// PolicyDrop busy room drop this message, count in Stats.Dropped
+	PolicyDrop Policy = iota
+
+	// PolicyBlock wait busy room, this will block server threads
+	PolicyBlock
+)
+
+// Engine is how to handle websocket connections
+type Engine int8
+
+const (
+	// EngineGoroutine every connection has read and write goroutines
+	EngineGoroutine Engine = iota
+
+	// EngineEpoll idle connection no goroutine, use epoll event loop
+	// Only linux, other platform or no file descriptor connection (e.g: TLS) fallback EngineGoroutine
+	EngineEpoll
+)
+
 // Config describes the configuration of the server.
 type Config struct {
 	// register, unregister room buffer count
@@ -7,6 +32,56 @@ type Config struct {
 	// broadcast message to room buffer count
 	CastBufferCount int
 
+	// Split rooms to multiple server threads by room name hash, 0 is 1
+	// Every shard has its own SignBufferCount and CastBufferCount
+	Shards int
+
+	// Allowed websocket request Origin header, e.g: "https://example.com"
+	// Empty or "*" allow all origins
+	Origins []string
+
+	// Websocket connections engine, default EngineGoroutine
+	Engine Engine
+
+	// Negotiate websocket per message compression (RFC 7692)
+	Compression bool
+
+	// Max websocket connections, 0 is unlimited
+	MaxConns int
+	// Max rooms, 0 is unlimited. Persistent, bots and MQTT subscriptions rooms also count
+	MaxRooms int
+	// Max concurrent websocket connections per remote IP, 0 is unlimited
+	MaxConnsPerIP int
+
+	// BroadcastAll busy room policy, default PolicyDrop
+	BroadcastAllPolicy Policy
+
+	// Persistent room messages, nil is disable
+	// Server.History query it
+	Store Store
+
+	// Topic wildcards, room levels split by "/"
+	// Client join "game/+/chat" or "game/#" room, receive all matched rooms messages
+	// Busy wildcard room drop the message, count in Stats.Dropped
+	Wildcard bool
+
+	// Speak MQTT 3.1.1 and 5 over websocket, client need subprotocol "mqtt"
+	// Topic "a/b" is room "/a/b", subscribe topic filters and publish QoS 0 or 1
+	// Subscription is a bot, wildcard topic filter need Wildcard
+	MQTT bool
+
+	// Negotiate MessagePack and CBOR by websocket subprotocol, look SubprotocolMsgpack
+	// Codec client binary message is typed payload, text message as JSON value
+	Codecs bool
+
+	// Persistent rooms, create when Run, exist without clients
+	// Server.CreateRoom create more, Server.DeleteRoom delete it
+	Rooms []string
+
+	// Extract room name from websocket request, nil is URL path
+	// Only for default OnConnected
+	Room *RoomRule
+
 	Worker WorkerConfig
 }
 
@@ -18,10 +93,43 @@ type WorkerConfig struct {
 	// broadcast message to client buffer count
 	CastBufferCount int
 
+	// Max clients per room, 0 is unlimited
+	MaxClients int
+
+	// Drain queued messages and write together, max messages per write
+	// 0 or 1 is disable, every message write alone
+	WriteBatch int
+	// Coalesce queued text messages into a single frame, need WriteBatch
+	Coalesce bool
+	// Coalesce text messages separator, e.g: "\n"
+	Separator string
+
+	// Pool received message buffers, less garbage under high message rate
+	// Message.Data and Record.Data are reused after broadcast, OnMessage, Store and bots need copy it if keep
+	PoolBuffers bool
+
+	// Room state, a key value map synchronized to clients
+	// Client set it by control message, look StateSet
+	State bool
+
+	// WebRTC signaling helper, route offer, answer, candidate to the named peer
+	// Announce peers on join and leave, look SignalOffer
+	Signaling bool
+
+	// Last client left, room wait this time before close
+	// New client join in this time, room keep alive. 0 is close immediately
+	Linger time.Duration
+	// Max lifetime per room, close all clients and the room when expired
+	// Persistent room also. 0 is unlimited
+	MaxLifetime time.Duration
+
 	// If you set this option as `false`
 	// The server will not broadcast to you messages you send.
 	// Look like MQTTv5 nolocal
 	Local bool
+
+	// Every client frame the message alone, benchmark baseline only
+	noPrepare bool
 }
 
 // DefaultConfig is a server with all fields set to the default values.
@@ -0,0 +1,276 @@
+package lightcable
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default file store segment size
+const defaultSegmentSize = 64 << 20
+
+const segmentExt = ".log"
+
+// FileStoreOptions is file store segments and retention
+type FileStoreOptions struct {
+	// Rotate a new segment when current segment larger than, 0 is 64 MiB
+	SegmentSize int64
+
+	// Remove oldest segments when total size larger than, 0 is unlimited
+	MaxSize int64
+
+	// Remove segments last message older than, 0 is unlimited
+	MaxAge time.Duration
+}
+
+// FileStore is embedded Store, append-only segment files in a directory
+// A segment is JSON Lines, a line is a Record
+// Retention check when open, rotate segment and append
+type FileStore struct {
+	dir  string
+	opts FileStoreOptions
+
+	mutex    sync.Mutex
+	segments []segment
+	file     *os.File
+}
+
+// segment file "<id>.log", id is increase
+type segment struct {
+	id   uint64
+	size int64
+
+	// last message time
+	modTime time.Time
+}
+
+func (s segment) name(dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", s.id, segmentExt))
+}
+
+// NewFileStore open or create dir, append to the last segment
+func NewFileStore(dir string, opts FileStoreOptions) (*FileStore, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStore{dir: dir, opts: opts}
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(info.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		fs.segments = append(fs.segments, segment{id: id, size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(fs.segments, func(i, j int) bool {
+		return fs.segments[i].id < fs.segments[j].id
+	})
+
+	if len(fs.segments) == 0 {
+		fs.segments = append(fs.segments, segment{id: 1, modTime: time.Now()})
+	}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	fs.retention()
+	return fs, nil
+}
+
+// open the last segment for append, truncate a partial last line
+func (fs *FileStore) open() error {
+	last := &fs.segments[len(fs.segments)-1]
+	f, err := os.OpenFile(last.name(fs.dir), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	size, err := lastLineEnd(f, last.size)
+	if err == nil && size != last.size {
+		err = f.Truncate(size)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	last.size = size
+	fs.file = f
+	return nil
+}
+
+// lastLineEnd the size after the last '\n', crash when writing maybe left a partial line
+func lastLineEnd(f *os.File, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for end := size; end > 0; {
+		n := int64(len(buf))
+		if end < n {
+			n = end
+		}
+		if _, err := f.ReadAt(buf[:n], end-n); err != nil {
+			return 0, err
+		}
+		for i := n - 1; i >= 0; i-- {
+			if buf[i] == '\n' {
+				return end - n + i + 1, nil
+			}
+		}
+		end -= n
+	}
+	return 0, nil
+}
+
+// Append a record as a line, rotate segment if current segment full
+func (fs *FileStore) Append(r *Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.file == nil {
+		return os.ErrClosed
+	}
+
+	last := &fs.segments[len(fs.segments)-1]
+	if last.size > 0 && last.size+int64(len(line)) > fs.opts.SegmentSize {
+		if err := fs.rotate(); err != nil {
+			return err
+		}
+		last = &fs.segments[len(fs.segments)-1]
+	}
+
+	if n, err := fs.file.Write(line); err != nil {
+		// Not leave a partial line, next line will be broken
+		if n > 0 {
+			fs.file.Truncate(last.size)
+		}
+		return err
+	}
+	last.size += int64(len(line))
+	last.modTime = r.Time
+	if fs.opts.MaxAge > 0 {
+		fs.retention()
+	}
+	return nil
+}
+
+func (fs *FileStore) rotate() error {
+	if err := fs.file.Close(); err != nil {
+		return err
+	}
+	fs.segments = append(fs.segments, segment{
+		id:      fs.segments[len(fs.segments)-1].id + 1,
+		modTime: time.Now(),
+	})
+	if err := fs.open(); err != nil {
+		fs.file = nil
+		return err
+	}
+	fs.retention()
+	return nil
+}
+
+// retention remove oldest segments, never remove current segment
+func (fs *FileStore) retention() {
+	var total int64
+	for _, s := range fs.segments {
+		total += s.size
+	}
+	for len(fs.segments) > 1 {
+		s := fs.segments[0]
+		if !(fs.opts.MaxSize > 0 && total > fs.opts.MaxSize) &&
+			!(fs.opts.MaxAge > 0 && time.Since(s.modTime) > fs.opts.MaxAge) {
+			return
+		}
+		os.Remove(s.name(fs.dir))
+		total -= s.size
+		fs.segments = fs.segments[1:]
+	}
+}
+
+// Query scan segments, skip segments last message before since
+func (fs *FileStore) Query(room string, since time.Time, limit int) ([]Record, error) {
+	fs.mutex.Lock()
+	segments := make([]segment, len(fs.segments))
+	copy(segments, fs.segments)
+	fs.mutex.Unlock()
+
+	records := []Record{}
+	for _, s := range segments {
+		if s.modTime.Before(since) {
+			continue
+		}
+		var err error
+		if records, err = fs.scan(s, room, since, limit, records); err != nil {
+			return records, err
+		}
+		if limit > 0 && len(records) >= limit {
+			break
+		}
+	}
+	return records, nil
+}
+
+// scan a segment, only read size when snapshot, the last line maybe writing
+func (fs *FileStore) scan(s segment, room string, since time.Time, limit int, records []Record) ([]Record, error) {
+	f, err := os.Open(s.name(fs.dir))
+	if err != nil {
+		// Removed by retention
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return records, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(io.LimitReader(f, s.size))
+	for limit <= 0 || len(records) < limit {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			return records, err
+		}
+		if record.Room == room && record.Time.After(since) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// Close the current segment, Append return error after closed
+func (fs *FileStore) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.file == nil {
+		return nil
+	}
+	err := fs.file.Close()
+	fs.file = nil
+	return err
+}
@@ -0,0 +1,227 @@
+package lightcable
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightcable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir, FileStoreOptions{SegmentSize: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		room := "/test"
+		if i%2 == 1 {
+			room = "/test-2"
+		}
+		if err := store.Append(&Record{
+			Room: room,
+			Name: "name",
+			Code: websocket.TextMessage,
+			Data: []byte{byte('a' + i)},
+			Time: start.Add(time.Duration(i) * time.Millisecond),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Rotate segments
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.log")); len(files) < 2 {
+		t.Error("Should rotate segments:", files)
+	}
+
+	records, err := store.Query("/test", time.Time{}, 0)
+	if err != nil || len(records) != 10 {
+		t.Fatal("Query:", len(records), err)
+	}
+	for i, r := range records {
+		if r.Room != "/test" || r.Name != "name" || r.Code != websocket.TextMessage || string(r.Data) != string(rune('a'+i*2)) {
+			t.Errorf("Record: %+v", r)
+		}
+	}
+
+	// since and limit
+	records, err = store.Query("/test", start.Add(10*time.Millisecond), 2)
+	if err != nil || len(records) != 2 || string(records[0].Data) != "m" || string(records[1].Data) != "o" {
+		t.Error("Query since limit:", records, err)
+	}
+
+	// Reopen, retention by size
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append(&Record{}); err == nil {
+		t.Error("Should closed")
+	}
+	if store, err = NewFileStore(dir, FileStoreOptions{SegmentSize: 256, MaxSize: 300}); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.log")); len(files) > 2 {
+		t.Error("Should remove old segments:", files)
+	}
+	records, err = store.Query("/test-2", time.Time{}, 0)
+	if err != nil || len(records) == 0 || len(records) == 10 || string(records[len(records)-1].Data) != "t" {
+		t.Error("Query after retention:", len(records), err)
+	}
+}
+
+func TestFileStorePartialLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightcable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append(&Record{Room: "/test", Data: []byte("a"), Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// Crash when writing
+	files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"room":"/test","da`)
+	f.Close()
+
+	if store, err = NewFileStore(dir, FileStoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Append(&Record{Room: "/test", Data: []byte("b"), Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.Query("/test", time.Time{}, 0)
+	if err != nil || len(records) != 2 || string(records[0].Data) != "a" || string(records[1].Data) != "b" {
+		t.Error("Query after partial line:", records, err)
+	}
+}
+
+func TestFileStoreMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightcable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	record := func(data string) *Record {
+		return &Record{Room: "/test", Data: []byte(data), Time: time.Now()}
+	}
+	// Longest line, time has 9 digits nanoseconds
+	longest := record("a")
+	longest.Time = longest.Time.Truncate(time.Second).Add(123456789)
+	line, _ := json.Marshal(longest)
+
+	// A segment is two records
+	store, err := NewFileStore(dir, FileStoreOptions{SegmentSize: int64(2 * (len(line) + 1)), MaxAge: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, data := range []string{"a", "b", "c"} {
+		if err := store.Append(record(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.log")); len(files) != 2 {
+		t.Fatal("Should rotate segments:", files)
+	}
+
+	// Not rotate, expired segment also removed
+	time.Sleep(150 * time.Millisecond)
+	if err := store.Append(record("d")); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.log")); len(files) != 1 {
+		t.Error("Should remove expired segment:", files)
+	}
+	records, err := store.Query("/test", time.Time{}, 0)
+	if err != nil || len(records) != 2 || string(records[0].Data) != "c" || string(records[1].Data) != "d" {
+		t.Error("Query after retention:", records, err)
+	}
+}
+
+func TestServerHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightcable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	cfg := *DefaultConfig
+	cfg.Store = store
+	server := New(&cfg)
+	if _, err := New(DefaultConfig).History("/test", time.Time{}, 0); err != ErrNoStore {
+		t.Error("Should ErrNoStore:", err)
+	}
+
+	conns := makeConns(t, server, "/test", "/test")
+	ws, ws2 := conns[0], conns[1]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	// Join in any order, the sender is one of them
+	names := map[string]bool{<-join: true, <-join: true}
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("xxx")); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := ws2.ReadMessage(); err != nil {
+		t.Error(err)
+	}
+	if _, err := server.BroadcastContext(context.Background(), "/test", "server", websocket.BinaryMessage, []byte("yyy")); err != nil {
+		t.Error(err)
+	}
+
+	records, err := server.History("/test", time.Time{}, 0)
+	if err != nil || len(records) != 2 {
+		t.Fatal("History:", records, err)
+	}
+	if r := records[0]; string(r.Data) != "xxx" || !names[r.Name] || r.Code != websocket.TextMessage || r.Time.IsZero() {
+		t.Errorf("Record: %+v", r)
+	}
+	if r := records[1]; string(r.Data) != "yyy" || r.Name != "server" || r.Code != websocket.BinaryMessage {
+		t.Errorf("Record: %+v", r)
+	}
+
+	cancel()
+	<-sign
+}
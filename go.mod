@@ -2,4 +2,8 @@ module github.com/a-wing/lightcable
 
 go 1.13
 
-require github.com/gorilla/websocket v1.5.0
+require (
+	github.com/BurntSushi/toml v0.4.1
+	github.com/gorilla/websocket v1.5.0
+	gopkg.in/yaml.v3 v3.0.1
+)
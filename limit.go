@@ -0,0 +1,182 @@
+package lightcable
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats is the server counters, for monitoring
+type Stats struct {
+	// Current websocket connections
+	Conns int
+	// Current rooms, include persistent rooms, bots and MQTT subscriptions rooms
+	Rooms int
+	// Current remote IPs
+	IPs int
+
+	// Total accepted websocket connections
+	Accepted uint64
+	// Total rejected websocket connections by limits
+	Rejected uint64
+
+	// Total server broadcast messages dropped by busy room, BroadcastAll, TryBroadcast and wildcard forward
+	Dropped uint64
+
+	// Total Config.Store and recorder append failed messages
+	StoreErrors uint64
+}
+
+// limiter limit connections before upgrade websocket
+// Need concurrent, so not in server threads
+type limiter struct {
+	// Stats.Accepted and Stats.Rejected, atomic add from ServeHTTP goroutines. First field is 8 byte aligned
+	accepted uint64
+	rejected uint64
+
+	maxConns      int
+	maxRooms      int
+	maxClients    int
+	maxConnsPerIP int
+
+	mutex sync.Mutex
+	conns int
+	rooms map[string]int
+	ips   map[string]int
+
+	// Rooms has a worker, shards update it
+	workers map[string]bool
+	// Rooms has a worker or connections
+	live int
+}
+
+func newLimiter(cfg *Config) *limiter {
+	return &limiter{
+		maxConns:      cfg.MaxConns,
+		maxRooms:      cfg.MaxRooms,
+		maxClients:    cfg.Worker.MaxClients,
+		maxConnsPerIP: cfg.MaxConnsPerIP,
+
+		rooms:   make(map[string]int),
+		ips:     make(map[string]int),
+		workers: make(map[string]bool),
+	}
+}
+
+// update the room, fn change it connections or worker, count live rooms
+func (l *limiter) update(room string, fn func()) {
+	before := l.rooms[room] > 0 || l.workers[room]
+	fn()
+	after := l.rooms[room] > 0 || l.workers[room]
+	switch {
+	case !before && after:
+		l.live++
+	case before && !after:
+		l.live--
+	}
+}
+
+// openRoom the room worker created
+func (l *limiter) openRoom(room string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.update(room, func() { l.workers[room] = true })
+}
+
+// closeRoom the room worker removed
+func (l *limiter) closeRoom(room string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.update(room, func() { delete(l.workers, room) })
+}
+
+// acquire a connection, need release when the connection closed
+func (l *limiter) acquire(room, ip string) *Rejection {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var rejection *Rejection
+	switch {
+	case l.maxConns > 0 && l.conns >= l.maxConns:
+		rejection = newUnavailable("Too Many Connections")
+	case l.maxRooms > 0 && l.rooms[room] == 0 && !l.workers[room] && l.live >= l.maxRooms:
+		rejection = newUnavailable("Too Many Rooms")
+	case l.maxClients > 0 && l.rooms[room] >= l.maxClients:
+		rejection = newUnavailable("Room Is Full")
+	case l.maxConnsPerIP > 0 && l.ips[ip] >= l.maxConnsPerIP:
+		rejection = &Rejection{Code: http.StatusTooManyRequests, Body: "Too Many Connections From This IP"}
+	}
+	if rejection != nil {
+		atomic.AddUint64(&l.rejected, 1)
+		return rejection
+	}
+
+	l.conns++
+	l.update(room, func() { l.rooms[room]++ })
+	l.ips[ip]++
+	atomic.AddUint64(&l.accepted, 1)
+	return nil
+}
+
+func (l *limiter) release(room, ip string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.conns--
+	l.update(room, func() {
+		if l.rooms[room]--; l.rooms[room] <= 0 {
+			delete(l.rooms, room)
+		}
+	})
+	if l.ips[ip]--; l.ips[ip] <= 0 {
+		delete(l.ips, ip)
+	}
+}
+
+// acquireRoom a room member without connection, MQTT subscription, need releaseRoom
+func (l *limiter) acquireRoom(room string) *Rejection {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	switch {
+	case l.maxRooms > 0 && l.rooms[room] == 0 && !l.workers[room] && l.live >= l.maxRooms:
+		return newUnavailable("Too Many Rooms")
+	case l.maxClients > 0 && l.rooms[room] >= l.maxClients:
+		return newUnavailable("Room Is Full")
+	}
+	l.update(room, func() { l.rooms[room]++ })
+	return nil
+}
+
+func (l *limiter) releaseRoom(room string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.update(room, func() {
+		if l.rooms[room]--; l.rooms[room] <= 0 {
+			delete(l.rooms, room)
+		}
+	})
+}
+
+func (l *limiter) stats() Stats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return Stats{
+		Conns:    l.conns,
+		Rooms:    l.live,
+		IPs:      len(l.ips),
+		Accepted: atomic.LoadUint64(&l.accepted),
+		Rejected: atomic.LoadUint64(&l.rejected),
+	}
+}
+
+// remoteIP of the request, no port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,148 @@
+package lightcable
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLimit(t *testing.T) {
+	server := New(&Config{
+		SignBufferCount: 128,
+		CastBufferCount: 128,
+		MaxConns:        3,
+		MaxRooms:        2,
+		MaxConnsPerIP:   3,
+		Worker: WorkerConfig{
+			SignBufferCount: 128,
+			CastBufferCount: 128,
+			MaxClients:      2,
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	leave := make(chan string)
+	server.OnConnClose(func(c *Client) {
+		leave <- c.Name
+	})
+	go server.Run(ctx)
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	// Keep connections reference, avoid closed by GC
+	var conns []*websocket.Conn
+	dial := func(room string, code int) *websocket.Conn {
+		conn, res, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+room), nil)
+		if code == http.StatusSwitchingProtocols {
+			if err != nil {
+				t.Error(err)
+			}
+			conns = append(conns, conn)
+			<-join
+		} else if err == nil || res.StatusCode != code {
+			t.Errorf("%s: Should reject %d: %v", room, code, err)
+		}
+		return conn
+	}
+
+	ws := dial("/test", http.StatusSwitchingProtocols)
+	dial("/test", http.StatusSwitchingProtocols)
+
+	// MaxClients
+	dial("/test", http.StatusServiceUnavailable)
+
+	dial("/test-2", http.StatusSwitchingProtocols)
+
+	// MaxRooms, MaxConns
+	dial("/test-3", http.StatusServiceUnavailable)
+
+	if stats := server.Stats(); stats.Conns != 3 || stats.Rooms != 2 || stats.IPs != 1 || stats.Accepted != 3 || stats.Rejected != 2 {
+		t.Errorf("Stats: %+v", stats)
+	}
+
+	ws.Close()
+	<-leave
+	if stats := server.Stats(); stats.Conns != 2 {
+		t.Errorf("Stats: %+v", stats)
+	}
+	dial("/test", http.StatusSwitchingProtocols)
+
+	cancel()
+	<-leave
+	<-leave
+	<-leave
+	<-sign
+}
+
+func TestLimitRooms(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.MaxRooms = 3
+	cfg.Rooms = []string{"/persistent"}
+	server := New(&cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	closed := make(chan string, 1)
+	server.OnRoomClose(func(room string) {
+		closed <- room
+	})
+	go server.Run(ctx)
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	// Persistent, created and bot rooms are counted
+	if err := server.CreateRoom(context.Background(), "/created"); err != nil {
+		t.Fatal(err)
+	}
+	bot, err := server.Join(context.Background(), "/bot", "bot", PermAll, func(*Message) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := server.Stats(); stats.Rooms != 3 || stats.Conns != 0 {
+		t.Errorf("Stats: %+v", stats)
+	}
+
+	// Too many rooms, but existing room is ok
+	if _, res, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/new"), nil); err == nil || res.StatusCode != http.StatusServiceUnavailable {
+		t.Error("Should reject too many rooms:", err)
+	}
+	ws, _, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/bot"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// Room closed not counted
+	if _, err := server.DeleteRoom(context.Background(), "/created"); err != nil {
+		t.Fatal(err)
+	}
+	if room := <-closed; room != "/created" {
+		t.Error("Should close:", room)
+	}
+	if stats := server.Stats(); stats.Rooms != 2 {
+		t.Errorf("Stats: %+v", stats)
+	}
+	ws2, _, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/new"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws2.Close()
+
+	bot.Leave()
+	cancel()
+	<-sign
+}
@@ -0,0 +1,572 @@
+package lightcable
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// MQTT over websocket subprotocol
+const mqttSubprotocol = "mqtt"
+
+// Max MQTT packet remaining length, larger close the connection
+const maxMQTTPacket = 1 << 20
+
+// MQTT control packet types
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+)
+
+// MQTT protocol level
+const (
+	mqttV311 = 4
+	mqttV5   = 5
+)
+
+var (
+	errMQTTPacket   = errors.New("Malformed MQTT Packet")
+	errMQTTProtocol = errors.New("MQTT Protocol Error")
+	errMQTTQoS      = errors.New("MQTT QoS 2 Not Supported")
+	errMQTTText     = errors.New("MQTT Need Binary Message")
+)
+
+// mqttSession is a MQTT client over websocket
+// Every subscription is a bot in the room, topic "a/b" => room "/a/b"
+// Topics limit in the connected room, connected "/a" allow "a" and "a/b"
+type mqttSession struct {
+	server *Server
+	conn   *websocket.Conn
+	room   string
+	name   string
+	perm   Permission
+
+	version   byte
+	keepalive time.Duration
+
+	// Abnormal close publish it, nil is no will or DISCONNECT
+	will *Message
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Topic filter subscriptions
+	mutex sync.Mutex
+	subs  map[string]*Bot
+
+	// Bots deliver concurrency, one writer at a time
+	writeMutex sync.Mutex
+}
+
+// serveMQTT the websocket connection speak MQTT, until the connection closed
+func (s *Server) serveMQTT(conn *websocket.Conn, room, name string, perm Permission, ip string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &mqttSession{
+		server: s,
+		conn:   conn,
+		room:   room,
+		name:   name,
+		perm:   perm,
+		ctx:    ctx,
+		cancel: cancel,
+		subs:   make(map[string]*Bot),
+	}
+
+	// Server closed, close the connection
+	go func() {
+		select {
+		case <-s.done:
+			conn.Close()
+		case <-ctx.Done():
+		}
+	}()
+
+	m.run()
+	m.close()
+	s.limiter.release(room, ip)
+}
+
+func (m *mqttSession) run() error {
+	r := bufio.NewReader(&wsStream{conn: m.conn})
+
+	// First packet must be CONNECT
+	m.conn.SetReadDeadline(time.Now().Add(writeWait))
+	header, body, err := readMQTTPacket(r)
+	if err != nil {
+		return err
+	}
+	if header>>4 != mqttConnect {
+		return errMQTTProtocol
+	}
+	if err := m.connect(body); err != nil {
+		return err
+	}
+
+	for {
+		// 1.5 times keep alive no packet, close the connection
+		var deadline time.Time
+		if m.keepalive > 0 {
+			deadline = time.Now().Add(m.keepalive * 3 / 2)
+		}
+		m.conn.SetReadDeadline(deadline)
+
+		header, body, err := readMQTTPacket(r)
+		if err != nil {
+			return err
+		}
+		switch header >> 4 {
+		case mqttPublish:
+			err = m.publish(header, body)
+		case mqttPuback:
+			// Deliver QoS 0, no need
+		case mqttSubscribe:
+			err = m.subscribe(body)
+		case mqttUnsubscribe:
+			err = m.unsubscribe(body)
+		case mqttPingreq:
+			err = m.write(mqttPingresp<<4, nil)
+		case mqttDisconnect:
+			m.will = nil
+			return nil
+		default:
+			err = errMQTTProtocol
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// close leave all rooms, abnormal close publish the will message
+func (m *mqttSession) close() {
+	m.mutex.Lock()
+	subs := m.subs
+	m.subs = nil
+	m.mutex.Unlock()
+	for _, bot := range subs {
+		bot.Leave()
+	}
+
+	if m.will != nil {
+		m.send(m.will.Room, m.will.Data)
+	}
+	m.cancel()
+	m.conn.Close()
+}
+
+func (m *mqttSession) connect(body []byte) error {
+	d := &mqttDecoder{b: body}
+	protocol := d.readString()
+	m.version = d.readByte()
+	if d.err != nil {
+		return d.err
+	}
+	if protocol != "MQTT" || (m.version != mqttV311 && m.version != mqttV5) {
+		// Unacceptable protocol version
+		if m.version == mqttV5 {
+			m.write(mqttConnack<<4, []byte{0, 0x84, 0})
+		} else {
+			m.write(mqttConnack<<4, []byte{0, 0x01})
+		}
+		return errMQTTProtocol
+	}
+
+	flags := d.readByte()
+	m.keepalive = time.Duration(d.readUint16()) * time.Second
+	m.skipProperties(d)
+
+	// Client identifier, client name is decided by OnConnect
+	d.readString()
+
+	if flags&0x04 != 0 {
+		m.skipProperties(d)
+		topic := d.readString()
+		payload := d.readBinary()
+		if d.err == nil && !validTopic(topic) {
+			return errMQTTProtocol
+		}
+		// No publish permission, drop the will
+		if m.perm.CanPublish() && m.authorize(topic) {
+			m.will = &Message{Room: "/" + topic, Data: payload}
+		}
+	}
+	// User name and password, auth is decided by OnConnect
+	if flags&0x80 != 0 {
+		d.readString()
+	}
+	if flags&0x40 != 0 {
+		d.readBinary()
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	if m.version == mqttV5 {
+		return m.write(mqttConnack<<4, []byte{0, 0, 0})
+	}
+	return m.write(mqttConnack<<4, []byte{0, 0})
+}
+
+func (m *mqttSession) publish(header byte, body []byte) error {
+	qos := header >> 1 & 3
+	if qos == 2 {
+		return errMQTTQoS
+	}
+
+	d := &mqttDecoder{b: body}
+	topic := d.readString()
+	var id uint16
+	if qos == 1 {
+		id = d.readUint16()
+	}
+	m.skipProperties(d)
+	payload := d.b
+	if d.err != nil {
+		return d.err
+	}
+	if !validTopic(topic) {
+		return errMQTTProtocol
+	}
+
+	// No publish permission, drop this message
+	if m.perm.CanPublish() && m.authorize(topic) {
+		if err := m.send("/"+topic, payload); err != nil {
+			return err
+		}
+	}
+	if qos == 1 {
+		return m.write(mqttPuback<<4, []byte{byte(id >> 8), byte(id)})
+	}
+	return nil
+}
+
+// validTopic PUBLISH and will topic name, not empty and no wildcard
+func validTopic(topic string) bool {
+	return topic != "" && !strings.ContainsAny(topic, WildcardLevel+WildcardMulti)
+}
+
+// authorize the topic or topic filter in the connected room
+// Connected "/" allow all topics
+func (m *mqttSession) authorize(topic string) bool {
+	room := "/" + topic
+	return m.room == "/" || room == m.room || strings.HasPrefix(room, m.room+"/")
+}
+
+// send message to the room, subscribed the same topic send as the bot
+func (m *mqttSession) send(room string, payload []byte) error {
+	code := websocket.BinaryMessage
+	if utf8.Valid(payload) {
+		code = websocket.TextMessage
+	}
+
+	m.mutex.Lock()
+	bot := m.subs[strings.TrimPrefix(room, "/")]
+	m.mutex.Unlock()
+	if bot != nil {
+		return bot.Send(code, payload)
+	}
+
+	msg := Message{
+		Name: m.name,
+		Room: room,
+		Code: code,
+		Data: payload,
+	}
+	m.server.onMessage(&msg)
+	select {
+	case m.server.shard(room).broadcast <- msg:
+		return nil
+	case <-m.server.done:
+		return ErrBufferFull
+	}
+}
+
+func (m *mqttSession) subscribe(body []byte) error {
+	d := &mqttDecoder{b: body}
+	id := d.readUint16()
+	m.skipProperties(d)
+
+	packet := []byte{byte(id >> 8), byte(id)}
+	if m.version == mqttV5 {
+		packet = append(packet, 0)
+	}
+	n := len(packet)
+	for len(d.b) != 0 && d.err == nil {
+		filter := d.readString()
+		// Subscription options, deliver QoS 0
+		d.readByte()
+		packet = append(packet, m.join(filter))
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(packet) == n {
+		return errMQTTProtocol
+	}
+	return m.write(mqttSuback<<4, packet)
+}
+
+// join the topic filter room as a bot, return granted QoS or failure
+func (m *mqttSession) join(filter string) byte {
+	const failure = 0x80
+	room := "/" + filter
+	if filter == "" || !m.perm.CanSubscribe() || !m.authorize(filter) {
+		return failure
+	}
+	// Need Config.Wildcard
+	if m.server.topics == nil && isWildcard(room) {
+		return failure
+	}
+
+	m.mutex.Lock()
+	_, ok := m.subs[filter]
+	m.mutex.Unlock()
+	if ok {
+		return 0
+	}
+
+	// Subscription is a room member count in limits, the connected room already counted
+	if room != m.room {
+		if rejection := m.server.limiter.acquireRoom(room); rejection != nil {
+			return failure
+		}
+	}
+	bot, err := m.server.Join(m.ctx, room, m.name, PermSubscribe, m.deliver)
+	if err != nil {
+		if room != m.room {
+			m.server.limiter.releaseRoom(room)
+		}
+		return failure
+	}
+	m.mutex.Lock()
+	m.subs[filter] = bot
+	m.mutex.Unlock()
+
+	// Kicked or room closed, the subscription lost, close the connection
+	go func() {
+		<-bot.Done()
+		if room != m.room {
+			m.server.limiter.releaseRoom(room)
+		}
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if m.subs[filter] == bot {
+			m.conn.Close()
+		}
+	}()
+	return 0
+}
+
+func (m *mqttSession) unsubscribe(body []byte) error {
+	d := &mqttDecoder{b: body}
+	id := d.readUint16()
+	m.skipProperties(d)
+
+	packet := []byte{byte(id >> 8), byte(id)}
+	if m.version == mqttV5 {
+		packet = append(packet, 0)
+	}
+	for len(d.b) != 0 && d.err == nil {
+		filter := d.readString()
+
+		m.mutex.Lock()
+		bot := m.subs[filter]
+		delete(m.subs, filter)
+		m.mutex.Unlock()
+
+		code := byte(0)
+		if bot != nil {
+			bot.Leave()
+		} else {
+			// No subscription existed
+			code = 0x11
+		}
+		if m.version == mqttV5 {
+			packet = append(packet, code)
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	return m.write(mqttUnsuback<<4, packet)
+}
+
+// deliver the room message as PUBLISH QoS 0, topic is the concrete room
+func (m *mqttSession) deliver(msg *Message) {
+	topic := strings.TrimPrefix(msg.Room, "/")
+	packet := make([]byte, 0, 2+len(topic)+1+len(msg.Data))
+	packet = appendMQTTString(packet, topic)
+	if m.version == mqttV5 {
+		packet = append(packet, 0)
+	}
+	packet = append(packet, msg.Data...)
+	if err := m.write(mqttPublish<<4, packet); err != nil {
+		m.conn.Close()
+	}
+}
+
+// write a MQTT packet as a websocket binary message
+func (m *mqttSession) write(header byte, body []byte) error {
+	packet := make([]byte, 0, 5+len(body))
+	packet = append(packet, header)
+	for n := len(body); ; {
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 128
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	m.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return m.conn.WriteMessage(websocket.BinaryMessage, packet)
+}
+
+// skipProperties MQTT 5 properties, not support any
+func (m *mqttSession) skipProperties(d *mqttDecoder) {
+	if m.version == mqttV5 {
+		d.next(d.readVarint())
+	}
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	return append(append(b, byte(len(s)>>8), byte(len(s))), s...)
+}
+
+// readMQTTPacket return fixed header first byte and the remaining
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errMQTTPacket
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&127) * multiplier
+		if b&128 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if length > maxMQTTPacket {
+		return 0, nil, errMQTTPacket
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// mqttDecoder read packet fields, short packet set err
+type mqttDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *mqttDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errMQTTPacket
+		return nil
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+func (d *mqttDecoder) readByte() byte {
+	if p := d.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (d *mqttDecoder) readUint16() uint16 {
+	if p := d.next(2); p != nil {
+		return uint16(p[0])<<8 | uint16(p[1])
+	}
+	return 0
+}
+
+func (d *mqttDecoder) readBinary() []byte {
+	return d.next(int(d.readUint16()))
+}
+
+func (d *mqttDecoder) readString() string {
+	return string(d.readBinary())
+}
+
+func (d *mqttDecoder) readVarint() int {
+	n, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		b := d.readByte()
+		n += int(b&127) * multiplier
+		if b&128 == 0 {
+			return n
+		}
+		multiplier *= 128
+	}
+	d.err = errMQTTPacket
+	return 0
+}
+
+// wsStream read websocket binary messages as a stream
+// MQTT packet maybe split to multiple messages, or a message has multiple packets
+type wsStream struct {
+	conn *websocket.Conn
+	r    io.Reader
+}
+
+func (s *wsStream) Read(p []byte) (int, error) {
+	for {
+		if s.r == nil {
+			code, r, err := s.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if code != websocket.BinaryMessage {
+				return 0, errMQTTText
+			}
+			s.r = r
+		}
+		n, err := s.r.Read(p)
+		if err == io.EOF {
+			s.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
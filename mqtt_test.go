@@ -0,0 +1,317 @@
+package lightcable
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// mqttPacket small packet, remaining length less than 128
+func mqttPacket(header byte, body ...byte) []byte {
+	return append([]byte{header, byte(len(body))}, body...)
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{0, byte(len(s))}, s...)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestMQTT(t *testing.T) {
+	for _, version := range []byte{mqttV311, mqttV5} {
+		testMQTT(t, version)
+	}
+}
+
+func testMQTT(t *testing.T, version byte) {
+	cfg := *DefaultConfig
+	cfg.MQTT = true
+	cfg.Wildcard = true
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	join := make(chan string, 4)
+	server.OnConnReady(func(c *Client) { join <- c.Room })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+
+	dialer := websocket.Dialer{Subprotocols: []string{"mqtt"}}
+	mq, _, err := dialer.Dial(makeWsProto(httpServer.URL+"/game"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mq.Subprotocol() != "mqtt" {
+		t.Fatal("Subprotocol:", mq.Subprotocol())
+	}
+	ws, _, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/game/1/chat"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-join
+
+	// v5 has properties length
+	var props []byte
+	if version == mqttV5 {
+		props = []byte{0}
+	}
+	write := func(packet []byte) {
+		if err := mq.WriteMessage(websocket.BinaryMessage, packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(packet []byte) {
+		if _, data, err := mq.ReadMessage(); err != nil || !bytes.Equal(data, packet) {
+			t.Errorf("v%d expect: %v, but: %v %v", version, packet, data, err)
+		}
+	}
+
+	// CONNECT, clean session, keep alive 60s
+	write(mqttPacket(0x10, concat(mqttString("MQTT"), []byte{version, 0x02, 0, 60}, props, mqttString("id"))...))
+	expect(mqttPacket(0x20, concat([]byte{0, 0}, props)...))
+
+	// SUBSCRIBE wildcard and a plain topic
+	write(mqttPacket(0x82, concat([]byte{0, 1}, props, mqttString("game/+/chat"), []byte{1}, mqttString("game/news"), []byte{0})...))
+	expect(mqttPacket(0x90, concat([]byte{0, 1}, props, []byte{0, 0})...))
+	<-join
+	<-join
+
+	// Websocket client to MQTT
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Error(err)
+	}
+	expect(mqttPacket(0x30, concat(mqttString("game/1/chat"), props, []byte("hello"))...))
+
+	// MQTT PUBLISH QoS 1 to websocket client
+	write(mqttPacket(0x32, concat(mqttString("game/1/chat"), []byte{0, 2}, props, []byte("world"))...))
+	if _, data, err := ws.ReadMessage(); err != nil || string(data) != "world" {
+		t.Error("Should recv from MQTT:", string(data), err)
+	}
+	// Subscribed by wildcard, recv itself. PUBACK and PUBLISH any order
+	packets := map[string]bool{
+		string(mqttPacket(0x40, 0, 2)): true,
+		string(mqttPacket(0x30, concat(mqttString("game/1/chat"), props, []byte("world"))...)): true,
+	}
+	for i := 0; i < 2; i++ {
+		_, data, err := mq.ReadMessage()
+		if err != nil || !packets[string(data)] {
+			t.Errorf("v%d unexpected: %v %v", version, data, err)
+		}
+		delete(packets, string(data))
+	}
+
+	// Server broadcast
+	server.Broadcast("/game/news", "server", websocket.TextMessage, []byte("news"))
+	expect(mqttPacket(0x30, concat(mqttString("game/news"), props, []byte("news"))...))
+
+	// PINGREQ
+	write(mqttPacket(0xC0))
+	expect(mqttPacket(0xD0))
+
+	// UNSUBSCRIBE
+	write(mqttPacket(0xA2, concat([]byte{0, 3}, props, mqttString("game/news"))...))
+	if version == mqttV5 {
+		expect(mqttPacket(0xB0, 0, 3, 0, 0))
+	} else {
+		expect(mqttPacket(0xB0, 0, 3))
+	}
+
+	// DISCONNECT
+	write(mqttPacket(0xE0))
+	if _, _, err := mq.ReadMessage(); err == nil {
+		t.Error("Should closed")
+	}
+
+	cancel()
+	<-sign
+}
+
+func TestMQTTUnsupportedVersion(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.MQTT = true
+	server := New(&cfg)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+
+	dialer := websocket.Dialer{Subprotocols: []string{"mqtt"}}
+	mq, _, err := dialer.Dial(makeWsProto(httpServer.URL+"/mqtt"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// MQTT 3.1
+	mq.WriteMessage(websocket.BinaryMessage, mqttPacket(0x10, concat(mqttString("MQIsdp"), []byte{3, 0x02, 0, 60}, mqttString("id"))...))
+	if _, data, err := mq.ReadMessage(); err != nil || !bytes.Equal(data, mqttPacket(0x20, 0, 0x01)) {
+		t.Error("Should unacceptable protocol version:", data, err)
+	}
+	if _, _, err := mq.ReadMessage(); err == nil {
+		t.Error("Should closed")
+	}
+
+	cancel()
+	<-sign
+}
+
+func TestMQTTWill(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.MQTT = true
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	server.OnConnected(func(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+		if r.URL.Query().Get("perm") == "subscribe" {
+			SetPermission(r, PermSubscribe)
+		}
+		return r.URL.Path, "", true
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	join := make(chan string, 1)
+	server.OnConnReady(func(c *Client) { join <- c.Room })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+
+	ws, _, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/news"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-join
+
+	// CONNECT with will, then abnormal close
+	dialer := websocket.Dialer{Subprotocols: []string{"mqtt"}}
+	connect := func(query, topic, payload string) *websocket.Conn {
+		mq, _, err := dialer.Dial(makeWsProto(httpServer.URL+"/news?"+query), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet := mqttPacket(0x10, concat(mqttString("MQTT"), []byte{mqttV311, 0x06, 0, 60}, mqttString("id"), mqttString(topic), mqttString(payload))...)
+		if err := mq.WriteMessage(websocket.BinaryMessage, packet); err != nil {
+			t.Fatal(err)
+		}
+		return mq
+	}
+
+	// Will topic is wildcard, close without CONNACK
+	mq := connect("", "news/#", "invalid")
+	mq.SetReadDeadline(time.Now().Add(time.Second))
+	if _, data, err := mq.ReadMessage(); err == nil {
+		t.Error("Should closed:", data)
+	}
+
+	// No publish permission, will dropped
+	mq = connect("perm=subscribe", "news", "forbidden")
+	if _, data, err := mq.ReadMessage(); err != nil || !bytes.Equal(data, mqttPacket(0x20, 0, 0)) {
+		t.Error("Should CONNACK:", data, err)
+	}
+	mq.Close()
+
+	mq = connect("", "news", "bye")
+	if _, data, err := mq.ReadMessage(); err != nil || !bytes.Equal(data, mqttPacket(0x20, 0, 0)) {
+		t.Error("Should CONNACK:", data, err)
+	}
+	mq.Close()
+
+	if _, data, err := ws.ReadMessage(); err != nil || string(data) != "bye" {
+		t.Error("Should recv will only with permission:", string(data), err)
+	}
+	ws.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := ws.ReadMessage(); err == nil {
+		t.Error("Should not recv will:", string(data))
+	}
+
+	cancel()
+	<-sign
+}
+
+func TestMQTTAuthorize(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.MQTT = true
+	cfg.Wildcard = true
+	cfg.Worker.Local = false
+	cfg.Worker.MaxClients = 1
+	server := New(&cfg)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	join := make(chan string, 16)
+	server.OnConnReady(func(c *Client) { join <- c.Room })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+
+	conns := makeConns(t, server, "/b", "/a/full")
+	ws, wsFull := conns[0], conns[1]
+	for i := 0; i < 2; i++ {
+		<-join
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{"mqtt"}}
+	mq, _, err := dialer.Dial(makeWsProto(httpServer.URL+"/a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mq.SetReadDeadline(time.Now().Add(5 * time.Second))
+	wsFull.SetReadDeadline(time.Now().Add(5 * time.Second))
+	write := func(packet []byte) {
+		if err := mq.WriteMessage(websocket.BinaryMessage, packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(packet []byte) {
+		if _, data, err := mq.ReadMessage(); err != nil || !bytes.Equal(data, packet) {
+			t.Errorf("expect: %v, but: %v %v", packet, data, err)
+		}
+	}
+	write(mqttPacket(0x10, concat(mqttString("MQTT"), []byte{mqttV311, 0x02, 0, 60}, mqttString("id"))...))
+	expect(mqttPacket(0x20, 0, 0))
+
+	// Admitted to "/a", only topics in it. "a/full" is full, subscription count in limits
+	filters := []string{"#", "b", "+/a", "a/#", "a", "a/full"}
+	body := []byte{0, 1}
+	for _, filter := range filters {
+		body = concat(body, mqttString(filter), []byte{0})
+	}
+	write(mqttPacket(0x82, body...))
+	expect(mqttPacket(0x90, 0, 1, 0x80, 0x80, 0x80, 0, 0, 0x80))
+	<-join
+	<-join
+
+	// Outside room dropped, still PUBACK
+	write(mqttPacket(0x32, concat(mqttString("b"), []byte{0, 2}, []byte("outside"))...))
+	expect(mqttPacket(0x40, 0, 2))
+
+	write(mqttPacket(0x30, concat(mqttString("a/full"), []byte("inside"))...))
+	expect(mqttPacket(0x30, concat(mqttString("a/full"), []byte("inside"))...))
+	if _, data, err := wsFull.ReadMessage(); err != nil || string(data) != "inside" {
+		t.Error("Should recv inside:", string(data), err)
+	}
+	ws.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := ws.ReadMessage(); err == nil {
+		t.Error("Should not recv outside:", string(data))
+	}
+
+	mq.Close()
+	cancel()
+	<-sign
+}
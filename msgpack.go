@@ -0,0 +1,207 @@
+package lightcable
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+)
+
+// msgpackCodec is MessagePack, https://github.com/msgpack/msgpack/blob/master/spec.md
+// Not support extension types
+type msgpackCodec struct{}
+
+func (msgpackCodec) index() int { return 0 }
+
+func (c msgpackCodec) encode(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int64:
+		return msgpackInt(b, v), nil
+	case uint64:
+		if v <= math.MaxInt64 {
+			return msgpackInt(b, int64(v)), nil
+		}
+		return append(append(b, 0xcf), uint64Bytes(v)...), nil
+	case float64:
+		return append(append(b, 0xcb), uint64Bytes(math.Float64bits(v))...), nil
+	case json.Number:
+		n, err := jsonNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		return c.encode(b, n)
+	case string:
+		b = msgpackLen(b, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		return append(b, v...), nil
+	case []byte:
+		b = msgpackLen(b, len(v), 0, 0, 0xc4, 0xc5, 0xc6)
+		return append(b, v...), nil
+	case []interface{}:
+		b = msgpackLen(b, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			var err error
+			if b, err = c.encode(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = msgpackLen(b, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		for key, item := range v {
+			var err error
+			b = msgpackLen(b, len(key), 0xa0, 32, 0xd9, 0xda, 0xdb)
+			b = append(b, key...)
+			if b, err = c.encode(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, errCodecType
+}
+
+// msgpackLen fix format less than fixMax, others 8, 16, 32 bits length format
+// c8 is 0, no 8 bits length format
+func msgpackLen(b []byte, n int, fix byte, fixMax int, c8, c16, c32 byte) []byte {
+	switch {
+	case n < fixMax:
+		return append(b, fix|byte(n))
+	case c8 != 0 && n <= math.MaxUint8:
+		return append(b, c8, byte(n))
+	case n <= math.MaxUint16:
+		return append(b, c16, byte(n>>8), byte(n))
+	}
+	return append(b, c32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func msgpackInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= 127:
+		return append(b, byte(n))
+	case n >= -32 && n < 0:
+		return append(b, byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		return append(b, 0xd0, byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		return append(b, 0xd1, byte(n>>8), byte(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		return append(b, 0xd2, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(append(b, 0xd3), uint64Bytes(uint64(n))...)
+}
+
+func uint64Bytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func (msgpackCodec) decode(data []byte) (interface{}, error) {
+	d := &msgpackDecoder{codecDecoder{b: data}}
+	v := d.value(0)
+	if d.err == nil && len(d.b) != 0 {
+		d.err = errCodecSyntax
+	}
+	return v, d.err
+}
+
+type msgpackDecoder struct {
+	codecDecoder
+}
+
+func (d *msgpackDecoder) value(depth int) interface{} {
+	if depth > maxCodecDepth {
+		d.err = errCodecMaxDepth
+		return nil
+	}
+	p := d.next(1)
+	if p == nil {
+		return nil
+	}
+	switch c := p[0]; {
+	case c <= 0x7f:
+		return int64(c)
+	case c >= 0xe0:
+		return int64(int8(c))
+	case c&0xe0 == 0xa0:
+		return string(d.next(int(c & 0x1f)))
+	case c&0xf0 == 0x90:
+		return d.array(int(c&0x0f), depth)
+	case c&0xf0 == 0x80:
+		return d.object(int(c&0x0f), depth)
+	}
+
+	switch c := p[0]; c {
+	case 0xc0:
+		return nil
+	case 0xc2:
+		return false
+	case 0xc3:
+		return true
+	case 0xc4, 0xc5, 0xc6:
+		n := d.uint(1 << (c - 0xc4))
+		return append([]byte{}, d.next(int(n))...)
+	case 0xca:
+		return float64(math.Float32frombits(uint32(d.uint(4))))
+	case 0xcb:
+		return math.Float64frombits(d.uint(8))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uint(1 << (c - 0xcc))
+	case 0xd0:
+		return int64(int8(d.uint(1)))
+	case 0xd1:
+		return int64(int16(d.uint(2)))
+	case 0xd2:
+		return int64(int32(d.uint(4)))
+	case 0xd3:
+		return int64(d.uint(8))
+	case 0xd9, 0xda, 0xdb:
+		n := d.uint(1 << (c - 0xd9))
+		return string(d.next(int(n)))
+	case 0xdc, 0xdd:
+		return d.array(int(d.uint(2<<(c-0xdc))), depth)
+	case 0xde, 0xdf:
+		return d.object(int(d.uint(2<<(c-0xde))), depth)
+	}
+	d.err = errCodecType
+	return nil
+}
+
+func (d *msgpackDecoder) array(n int, depth int) interface{} {
+	// Every item at least 1 byte
+	if n > len(d.b) {
+		d.err = errCodecSyntax
+		return nil
+	}
+	items := make([]interface{}, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		items = append(items, d.value(depth+1))
+	}
+	return items
+}
+
+func (d *msgpackDecoder) object(n int, depth int) interface{} {
+	// Every key value at least 2 bytes
+	if n > len(d.b)/2 {
+		d.err = errCodecSyntax
+		return nil
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		key, ok := d.value(depth + 1).(string)
+		if !ok {
+			if d.err == nil {
+				d.err = errCodecMapKey
+			}
+			return nil
+		}
+		m[key] = d.value(depth + 1)
+	}
+	return m
+}
@@ -0,0 +1,60 @@
+package lightcable
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrForbidden no permission to do it, e.g: a bot without PermPublish send
+var ErrForbidden = errors.New("Permission Forbidden")
+
+// Permission is websocket client permission in the room
+type Permission uint8
+
+const (
+	// PermPublish client can send message to the room
+	PermPublish Permission = 1 << iota
+	// PermSubscribe client can receive the room messages
+	PermSubscribe
+
+	// PermAll client can send and receive, this is default
+	PermAll = PermPublish | PermSubscribe
+)
+
+// CanPublish client can send message to the room
+func (p Permission) CanPublish() bool {
+	return p&PermPublish != 0
+}
+
+// CanSubscribe client can receive the room messages
+func (p Permission) CanSubscribe() bool {
+	return p&PermSubscribe != 0
+}
+
+type connOptionsKey struct{}
+
+// connOptions is OnConnected decided, this websocket connection options
+type connOptions struct {
+	perm Permission
+}
+
+func withConnOptions(r *http.Request) (*http.Request, *connOptions) {
+	opts := &connOptions{
+		perm: PermAll,
+	}
+	return r.WithContext(context.WithValue(r.Context(), connOptionsKey{}, opts)), opts
+}
+
+// SetPermission set this websocket connection permission, default PermAll
+// Only use in OnConnected callback
+//
+//	server.OnConnected(func(w http.ResponseWriter, r *http.Request) (room, name string, ok bool) {
+//		lightcable.SetPermission(r, lightcable.PermSubscribe)
+//		return r.URL.Path, "viewer", true
+//	})
+func SetPermission(r *http.Request, perm Permission) {
+	if opts, ok := r.Context().Value(connOptionsKey{}).(*connOptions); ok {
+		opts.perm = perm
+	}
+}
@@ -0,0 +1,79 @@
+package lightcable
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPermission(t *testing.T) {
+	server := New(DefaultConfig)
+	server.OnConnected(func(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+		name := r.URL.Query().Get("name")
+		if name == "presenter" {
+			SetPermission(r, PermPublish)
+		} else {
+			SetPermission(r, PermSubscribe)
+		}
+		return r.URL.Path, name, true
+	})
+	conns := makeConns(t, server, "/test?name=presenter", "/test?name=viewer", "/test?name=viewer")
+	presenter, viewer, viewer2 := conns[0], conns[1], conns[2]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	recv := make(chan string, 2)
+	server.OnMessage(func(m *Message) {
+		recv <- m.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	<-join
+	<-join
+	<-join
+
+	// viewer no publish permission
+	if err := viewer.WriteMessage(websocket.TextMessage, []byte("viewer")); err != nil {
+		t.Error(err)
+	}
+	if err := presenter.WriteMessage(websocket.TextMessage, []byte("presenter")); err != nil {
+		t.Error(err)
+	}
+	if name := <-recv; name != "presenter" {
+		t.Error("Should drop viewer message, but:", name)
+	}
+
+	for _, conn := range []*websocket.Conn{viewer, viewer2} {
+		if _, data, err := conn.ReadMessage(); err != nil {
+			t.Error(err)
+		} else if string(data) != "presenter" {
+			t.Errorf("ReadMessage is: %s", data)
+		}
+	}
+
+	// presenter no subscribe permission
+	server.Broadcast("/test", "test", websocket.TextMessage, []byte("xxx"))
+	if _, data, err := viewer.ReadMessage(); err != nil || string(data) != "xxx" {
+		t.Error(data, err)
+	}
+	if err := presenter.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := presenter.ReadMessage(); err == nil {
+		t.Error("Should have error")
+	}
+
+	cancel()
+	<-sign
+}
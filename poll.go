@@ -0,0 +1,374 @@
+package lightcable
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// epoll wait timeout milliseconds, check server closed
+const pollTimeout = 100
+
+var (
+	errPollerClosed = errors.New("Poller Closed")
+	errFrameLength  = errors.New("Bad Frame Length")
+	errPongTimeout  = errors.New("Pong Timeout")
+)
+
+// poller is epoll engine, idle connection no goroutine
+// readable connection run a goroutine read a message, then rearm
+type poller struct {
+	epoll *epoll
+
+	mutex   sync.Mutex
+	closed  bool
+	clients map[int]*Client
+}
+
+func newPoller() (*poller, error) {
+	ep, err := newEpoll()
+	if err != nil {
+		return nil, err
+	}
+	return &poller{
+		epoll:   ep,
+		clients: make(map[int]*Client),
+	}, nil
+}
+
+func (p *poller) add(c *Client) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return errPollerClosed
+	}
+	if err := p.epoll.add(c.fd); err != nil {
+		return err
+	}
+	p.clients[c.fd] = c
+	return nil
+}
+
+// rearm the client readable event
+// fd maybe reused by new connection, so check the client
+func (p *poller) rearm(c *Client) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.clients[c.fd] != c {
+		return errPollerClosed
+	}
+	return p.epoll.rearm(c.fd)
+}
+
+// remove the client, need before close the connection
+func (p *poller) remove(c *Client) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.clients[c.fd] == c {
+		delete(p.clients, c.fd)
+		p.epoll.del(c.fd)
+	}
+}
+
+func (p *poller) snapshot() []*Client {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	clients := make([]*Client, 0, len(p.clients))
+	for _, c := range p.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+func (p *poller) run(ctx context.Context) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		p.close()
+	}()
+
+	var fds []int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			go p.ping(p.snapshot())
+		default:
+		}
+
+		var err error
+		if fds, err = p.epoll.wait(fds[:0], pollTimeout); err != nil {
+			return
+		}
+		p.mutex.Lock()
+		for _, fd := range fds {
+			if c, ok := p.clients[fd]; ok {
+				go c.readEvent()
+			}
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// close all clients, no more new clients
+func (p *poller) close() {
+	p.mutex.Lock()
+	p.closed = true
+	p.mutex.Unlock()
+
+	for _, c := range p.snapshot() {
+		c.conn.WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(writeWait))
+		c.detach(nil)
+	}
+	p.epoll.close()
+}
+
+// ping all clients, close no pong timeout clients
+func (p *poller) ping(clients []*Client) {
+	now := time.Now()
+	for _, c := range clients {
+		if now.Sub(time.Unix(0, atomic.LoadInt64(&c.active))) > pongWait {
+			c.detach(errPongTimeout)
+			continue
+		}
+		c.conn.WriteControl(websocket.PingMessage, nil, now.Add(writeWait))
+	}
+}
+
+// frameConn is hijacked connection, read never cross websocket frame boundary
+// So websocket read buffer no data remain after a message, epoll readable is exact
+type frameConn struct {
+	net.Conn
+
+	header [14]byte
+
+	// header not yet read by websocket
+	pending []byte
+
+	// current frame payload not yet read
+	remain int64
+}
+
+// readHeader read next frame header
+func (c *frameConn) readHeader() error {
+	if _, err := io.ReadFull(c.Conn, c.header[:2]); err != nil {
+		return err
+	}
+	n := 2
+	switch c.header[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if c.header[1]&0x80 != 0 {
+		n += 4
+	}
+	if _, err := io.ReadFull(c.Conn, c.header[2:n]); err != nil {
+		return err
+	}
+
+	switch length := int64(c.header[1] & 0x7f); length {
+	case 126:
+		c.remain = int64(binary.BigEndian.Uint16(c.header[2:]))
+	case 127:
+		c.remain = int64(binary.BigEndian.Uint64(c.header[2:]))
+	default:
+		c.remain = length
+	}
+	if c.remain < 0 {
+		return errFrameLength
+	}
+	c.pending = c.header[:n]
+	return nil
+}
+
+func (c *frameConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 && c.remain == 0 {
+		if err := c.readHeader(); err != nil {
+			return 0, err
+		}
+	}
+	if len(c.pending) != 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if int64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.Conn.Read(p)
+	c.remain -= int64(n)
+	return n, err
+}
+
+// control read next frame header at message boundary
+// ping and pong frame read the payload, others remain for websocket read
+func (c *frameConn) control() (int, []byte, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, nil, err
+	}
+	op := int(c.header[0] & 0x0f)
+	if (op != websocket.PingMessage && op != websocket.PongMessage) || c.remain > 125 {
+		return op, nil, nil
+	}
+
+	payload := make([]byte, c.remain)
+	if _, err := io.ReadFull(c.Conn, payload); err != nil {
+		return op, nil, err
+	}
+	if c.header[1]&0x80 != 0 {
+		key := c.pending[len(c.pending)-4:]
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	c.pending, c.remain = nil, 0
+	return op, payload, nil
+}
+
+// hijacker hijacked connection wrap as frameConn for epoll engine, batchConn for write batching
+type hijacker struct {
+	http.ResponseWriter
+	epoll bool
+	batch bool
+
+	conn *frameConn
+	bc   *batchConn
+}
+
+func (h *hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker not implemented")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.epoll {
+		h.conn = &frameConn{Conn: conn}
+		conn = h.conn
+	}
+	if h.batch {
+		// Outermost, frameConn keep the raw connection file descriptor
+		h.bc = &batchConn{Conn: conn}
+		conn = h.bc
+	}
+	return conn, brw, nil
+}
+
+// connFd file descriptor of the connection, no file descriptor (e.g: TLS) return -1
+func connFd(conn net.Conn) int {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return -1
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return -1
+	}
+	fd := -1
+	raw.Control(func(p uintptr) {
+		fd = int(p)
+	})
+	return fd
+}
+
+// attach the client to epoll engine, no file descriptor keep two goroutines
+func (c *Client) attach(p *poller, fc *frameConn) {
+	if p == nil || fc == nil {
+		return
+	}
+	if c.fd = connFd(fc.Conn); c.fd < 0 {
+		return
+	}
+	c.poll, c.fc = p, fc
+	atomic.StoreInt64(&c.active, time.Now().UnixNano())
+	c.conn.SetPongHandler(func(string) error {
+		atomic.StoreInt64(&c.active, time.Now().UnixNano())
+		return nil
+	})
+}
+
+// readEvent the connection readable, read a message or a control frame
+func (c *Client) readEvent() {
+	atomic.StoreInt64(&c.active, time.Now().UnixNano())
+	err := c.conn.SetReadDeadline(time.Now().Add(pongWait))
+
+	var op int
+	var payload []byte
+	if err == nil {
+		op, payload, err = c.fc.control()
+	}
+	switch {
+	case err != nil:
+	case op == websocket.PingMessage:
+		err = c.conn.WriteControl(websocket.PongMessage, payload, time.Now().Add(writeWait))
+	case op == websocket.PongMessage:
+	default:
+		var code int
+		var buf *buffer
+		if code, buf, err = c.readMessage(); err == nil {
+			c.receive(code, buf)
+		}
+	}
+
+	if err == nil {
+		err = c.poll.rearm(c)
+	}
+	if err != nil {
+		c.detach(err)
+	}
+}
+
+// wake lazy writer, epoll engine no writePump goroutine
+func (c *Client) wake() {
+	if c.poll != nil && atomic.CompareAndSwapInt32(&c.writing, 0, 1) {
+		go c.flush()
+	}
+}
+
+// flush lazy writer, write all queued messages then exit
+func (c *Client) flush() {
+	var msgs []Message
+	for {
+		select {
+		case msg, ok := <-c.send:
+			var err error
+			if msgs, ok, err = c.flushMessage(msg, ok, msgs); err != nil || !ok {
+				c.detach(err)
+				return
+			}
+		default:
+			atomic.StoreInt32(&c.writing, 0)
+
+			// Message maybe queued before writing reset
+			if len(c.send) == 0 || !atomic.CompareAndSwapInt32(&c.writing, 0, 1) {
+				return
+			}
+		}
+	}
+}
+
+// detach the client from epoll engine, unregister from the room and close
+// Only first err is Client.Err
+func (c *Client) detach(err error) {
+	c.once.Do(func() {
+		c.Err = err
+		c.poll.remove(c)
+		c.worker.unregister <- c
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,65 @@
+//go:build linux
+// +build linux
+
+package lightcable
+
+import (
+	"syscall"
+)
+
+// epoll is linux epoll instance, one shot level trigger
+type epoll struct {
+	fd     int
+	events []syscall.EpollEvent
+}
+
+func newEpoll() (*epoll, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &epoll{
+		fd:     fd,
+		events: make([]syscall.EpollEvent, 128),
+	}, nil
+}
+
+func (e *epoll) ctl(op, fd int) error {
+	return syscall.EpollCtl(e.fd, op, fd, &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+		Fd:     int32(fd),
+	})
+}
+
+// add fd, fd readable report once
+func (e *epoll) add(fd int) error {
+	return e.ctl(syscall.EPOLL_CTL_ADD, fd)
+}
+
+// rearm fd, after reported need rearm
+func (e *epoll) rearm(fd int) error {
+	return e.ctl(syscall.EPOLL_CTL_MOD, fd)
+}
+
+func (e *epoll) del(fd int) error {
+	return syscall.EpollCtl(e.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait readable fds, append to fds. timeout is milliseconds
+func (e *epoll) wait(fds []int, timeout int) ([]int, error) {
+	n, err := syscall.EpollWait(e.fd, e.events, timeout)
+	if err == syscall.EINTR {
+		return fds, nil
+	}
+	if err != nil {
+		return fds, err
+	}
+	for _, event := range e.events[:n] {
+		fds = append(fds, int(event.Fd))
+	}
+	return fds, nil
+}
+
+func (e *epoll) close() error {
+	return syscall.Close(e.fd)
+}
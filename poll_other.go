@@ -0,0 +1,37 @@
+//go:build !linux
+// +build !linux
+
+package lightcable
+
+import (
+	"errors"
+)
+
+var errEpoll = errors.New("epoll only support linux")
+
+// epoll not support this platform, EngineEpoll fallback EngineGoroutine
+type epoll struct{}
+
+func newEpoll() (*epoll, error) {
+	return nil, errEpoll
+}
+
+func (e *epoll) add(fd int) error {
+	return errEpoll
+}
+
+func (e *epoll) rearm(fd int) error {
+	return errEpoll
+}
+
+func (e *epoll) del(fd int) error {
+	return errEpoll
+}
+
+func (e *epoll) wait(fds []int, timeout int) ([]int, error) {
+	return fds, errEpoll
+}
+
+func (e *epoll) close() error {
+	return errEpoll
+}
@@ -0,0 +1,107 @@
+package lightcable
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestEngineEpoll(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("epoll only support linux")
+	}
+
+	cfg := *DefaultConfig
+	cfg.Engine = EngineEpoll
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	if server.poller == nil {
+		t.Fatal("Should epoll engine")
+	}
+	conns := makeConns(t, server, "/test", "/test")
+	ws, ws2 := conns[0], conns[1]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan *Client)
+	server.OnConnReady(func(c *Client) {
+		join <- c
+	})
+	leave := make(chan string)
+	server.OnConnClose(func(c *Client) {
+		leave <- c.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	for _, c := range []*Client{<-join, <-join} {
+		if c.poll == nil {
+			t.Error("Should attach epoll engine")
+		}
+	}
+
+	// Large message, client write as multiple frames
+	data := bytes.Repeat([]byte("lightcable"), 10000)
+	for i := 0; i < 3; i++ {
+		if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+		if code, recv, err := ws2.ReadMessage(); err != nil || code != websocket.BinaryMessage || !bytes.Equal(recv, data) {
+			t.Error("Should recv large message:", code, len(recv), err)
+		}
+	}
+
+	// Ping from client, server pong
+	pong := make(chan string, 1)
+	ws.SetPongHandler(func(data string) error {
+		pong <- data
+		return nil
+	})
+	if err := ws.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(time.Second)); err != nil {
+		t.Error(err)
+	}
+	// Same connection in order, server already pong
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("ping sent")); err != nil {
+		t.Error(err)
+	}
+	if _, recv, err := ws2.ReadMessage(); err != nil || string(recv) != "ping sent" {
+		t.Error("Should recv ping sent:", string(recv), err)
+	}
+	if err := ws2.WriteMessage(websocket.TextMessage, []byte("after ping")); err != nil {
+		t.Error(err)
+	}
+	if _, recv, err := ws.ReadMessage(); err != nil || string(recv) != "after ping" {
+		t.Error("Should recv after ping:", string(recv), err)
+	}
+	select {
+	case data := <-pong:
+		if data != "ping" {
+			t.Error("Pong data:", data)
+		}
+	default:
+		t.Error("Should recv pong")
+	}
+
+	// Kick, lazy writer close the connection
+	clients, err := server.Clients(context.Background(), "/test")
+	if err != nil || len(clients) != 2 {
+		t.Fatal("Clients:", len(clients), err)
+	}
+	if _, err := server.Kick(context.Background(), "/test", clients[0].Name); err != nil {
+		t.Error(err)
+	}
+	if name := <-leave; name != clients[0].Name {
+		t.Error("Should leave:", name)
+	}
+
+	cancel()
+	<-leave
+	<-sign
+}
@@ -0,0 +1,117 @@
+package lightcable
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Max HTTP request body size of publish message
+const maxPublishSize = 1 << 20
+
+// Publisher is a http.Handler, publish message into rooms by HTTP
+// For backends without websocket clients
+//
+//	POST /broadcast                 all rooms
+//	POST /rooms/{room}/messages     a room
+//
+// room is websocket URL path, "/rooms/xxx/messages" => room: "/xxx"
+// Content-Type "text/*" and "application/json" is websocket TextMessage,
+// others is BinaryMessage
+type Publisher struct {
+	server *Server
+
+	onAuth func(r *http.Request) (name string, ok bool)
+}
+
+// PublishResult is Publisher HTTP response body
+type PublishResult struct {
+	Room string `json:"room,omitempty"`
+
+	// Count of websocket clients received this message
+	Clients int `json:"clients"`
+
+	// Some busy rooms dropped this message, only all rooms
+	Dropped bool `json:"dropped,omitempty"`
+}
+
+// NewPublisher creates a new Publisher, publish to this server
+func NewPublisher(server *Server) *Publisher {
+	return &Publisher{
+		server: server,
+		onAuth: func(r *http.Request) (name string, ok bool) {
+			return "http", true
+		},
+	}
+}
+
+// OnAuth auth this HTTP request callback
+// name: message sender name, the same as websocket client name
+// ok: true Allows publish; false Reject 401 Unauthorized
+func (p *Publisher) OnAuth(fn func(r *http.Request) (name string, ok bool)) {
+	p.onAuth = fn
+}
+
+// ServeHTTP Interface 'http.Handler'.
+func (p *Publisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var room string
+	all := r.URL.Path == "/broadcast"
+	if !all {
+		if !strings.HasPrefix(r.URL.Path, "/rooms/") || !strings.HasSuffix(r.URL.Path, "/messages") {
+			http.NotFound(w, r)
+			return
+		}
+		room = strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/rooms"), "/messages")
+		if room == "" || room == "/" {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	name, ok := p.onAuth(r)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPublishSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var count int
+	code := messageCode(r.Header.Get("Content-Type"))
+	if all {
+		count, err = p.server.BroadcastAllContext(r.Context(), name, code, data)
+	} else {
+		count, err = p.server.BroadcastContext(r.Context(), room, name, code, data)
+	}
+	if err != nil && err != ErrDropped {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, PublishResult{
+		Room:    room,
+		Clients: count,
+		Dropped: err == ErrDropped,
+	})
+}
+
+// messageCode websocket Opcode of HTTP Content-Type
+func messageCode(contentType string) int {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" {
+		return websocket.TextMessage
+	}
+	return websocket.BinaryMessage
+}
@@ -0,0 +1,105 @@
+package lightcable
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPublisher(t *testing.T) {
+	server := New(DefaultConfig)
+	conns := makeConns(t, server, "/test", "/test", "/test-2")
+	ws, ws2, ws3 := conns[0], conns[1], conns[2]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	<-join
+	<-join
+	<-join
+
+	publisher := NewPublisher(server)
+	publisher.OnAuth(func(r *http.Request) (string, bool) {
+		return "http", r.Header.Get("Authorization") == "Bearer token"
+	})
+	httpServer := httptest.NewServer(publisher)
+	defer httpServer.Close()
+
+	post := func(path, contentType, body string, token bool) (*http.Response, PublishResult) {
+		req, err := http.NewRequest(http.MethodPost, httpServer.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		if token {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var result PublishResult
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+				t.Error(err)
+			}
+		}
+		return res, result
+	}
+
+	if res, _ := post("/rooms/test/messages", "text/plain", "xxx", false); res.StatusCode != http.StatusUnauthorized {
+		t.Error("Should Unauthorized:", res.StatusCode)
+	}
+
+	if res, _ := post("/xxx", "text/plain", "xxx", true); res.StatusCode != http.StatusNotFound {
+		t.Error("Should NotFound:", res.StatusCode)
+	}
+
+	res, result := post("/rooms/test/messages", "text/plain; charset=utf-8", "xxx", true)
+	if res.StatusCode != http.StatusOK {
+		t.Error("Should OK:", res.StatusCode)
+	}
+	if result.Room != "/test" || result.Clients != 2 {
+		t.Errorf("Publish result: %+v", result)
+	}
+	for _, conn := range []*websocket.Conn{ws, ws2} {
+		if code, data, err := conn.ReadMessage(); err != nil {
+			t.Error(err)
+		} else if code != websocket.TextMessage || string(data) != "xxx" {
+			t.Errorf("ReadMessage is: %d, %s", code, data)
+		}
+	}
+
+	if _, result := post("/rooms/none/messages", "text/plain", "xxx", true); result.Clients != 0 {
+		t.Errorf("Publish result: %+v", result)
+	}
+
+	if _, result := post("/broadcast", "application/octet-stream", "yyy", true); result.Clients != 3 {
+		t.Errorf("Publish result: %+v", result)
+	}
+	for _, conn := range []*websocket.Conn{ws, ws2, ws3} {
+		if code, data, err := conn.ReadMessage(); err != nil {
+			t.Error(err)
+		} else if code != websocket.BinaryMessage || string(data) != "yyy" {
+			t.Errorf("ReadMessage is: %d, %s", code, data)
+		}
+	}
+
+	cancel()
+	<-sign
+}
@@ -0,0 +1,60 @@
+package lightcable
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// receipt count a message delivered clients, message maybe route to multiple workers
+// Every route need add, and every worker deliver once.
+// The sender hold one, need deliver(0) after route done
+type receipt struct {
+	// Delivered clients sum of every worker, atomic int64 first, pending and dropped int32 no need align
+	count int64
+
+	pending int32
+	dropped int32
+	done    chan struct{}
+}
+
+func newReceipt() *receipt {
+	return &receipt{
+		pending: 1,
+		done:    make(chan struct{}),
+	}
+}
+
+func (r *receipt) add() {
+	if r != nil {
+		atomic.AddInt32(&r.pending, 1)
+	}
+}
+
+func (r *receipt) deliver(n int) {
+	if r != nil {
+		atomic.AddInt64(&r.count, int64(n))
+		if atomic.AddInt32(&r.pending, -1) == 0 {
+			close(r.done)
+		}
+	}
+}
+
+// drop the route is busy, message dropped
+func (r *receipt) drop() {
+	if r != nil {
+		atomic.AddInt32(&r.dropped, 1)
+		r.deliver(0)
+	}
+}
+
+func (r *receipt) wait(ctx context.Context) (int, error) {
+	select {
+	case <-r.done:
+		if atomic.LoadInt32(&r.dropped) != 0 {
+			return int(atomic.LoadInt64(&r.count)), ErrDropped
+		}
+		return int(atomic.LoadInt64(&r.count)), nil
+	case <-ctx.Done():
+		return int(atomic.LoadInt64(&r.count)), ctx.Err()
+	}
+}
@@ -0,0 +1,166 @@
+package lightcable
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRecording the room already has a recorder
+var ErrRecording = errors.New("Room Already Recording")
+
+// Recorder write room messages to a recording, one recording can record many rooms
+//
+// Recording format is JSON Lines, a line is a Record, time ascending:
+//
+//	{"room": "/xxx", "name": "alice", "code": 1, "data": "aGVsbG8=", "time": "2022-01-01T00:00:00.123456789Z"}
+//
+// room and name is the sender, code is websocket opcode: 1 text, 2 binary
+// data is base64 payload, time is RFC 3339 when the room received it
+// The same as FileStore segment, a segment file also can replay
+type Recorder struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewRecorder write recording to w, w need buffer by caller if need
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Append a record as a line, Maybe Concurrent
+func (r *Recorder) Append(rec *Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, err = r.w.Write(line)
+	return err
+}
+
+// recorders the recording rooms, room recorder keep even if the room closed
+type recorders struct {
+	// atomic, recording rooms count include all, 0 is fast path
+	count int32
+
+	mutex sync.RWMutex
+	rooms map[string]*Recorder
+
+	// Record all rooms, nil is not
+	all *Recorder
+}
+
+// Record start record all messages of the room to the recorder
+// The room no need exist, keep recording when the room closed and reopen
+// Write error count in Stats.StoreErrors
+func (s *Server) Record(room string, r *Recorder) error {
+	s.recorders.mutex.Lock()
+	defer s.recorders.mutex.Unlock()
+	if _, ok := s.recorders.rooms[room]; ok {
+		return ErrRecording
+	}
+	if s.recorders.rooms == nil {
+		s.recorders.rooms = make(map[string]*Recorder)
+	}
+	s.recorders.rooms[room] = r
+	atomic.AddInt32(&s.recorders.count, 1)
+	return nil
+}
+
+// StopRecord stop record the room, return the recorder, nil is no recording
+func (s *Server) StopRecord(room string) *Recorder {
+	s.recorders.mutex.Lock()
+	defer s.recorders.mutex.Unlock()
+	r, ok := s.recorders.rooms[room]
+	if ok {
+		delete(s.recorders.rooms, room)
+		atomic.AddInt32(&s.recorders.count, -1)
+	}
+	return r
+}
+
+// RecordAll start record all rooms messages to the recorder, the room recorder first
+// No room state keep, rooms closed not leak
+func (s *Server) RecordAll(r *Recorder) error {
+	s.recorders.mutex.Lock()
+	defer s.recorders.mutex.Unlock()
+	if s.recorders.all != nil {
+		return ErrRecording
+	}
+	s.recorders.all = r
+	atomic.AddInt32(&s.recorders.count, 1)
+	return nil
+}
+
+// StopRecordAll stop record all rooms, return the recorder, nil is no recording
+// Room recorders of Record keep recording
+func (s *Server) StopRecordAll() *Recorder {
+	s.recorders.mutex.Lock()
+	defer s.recorders.mutex.Unlock()
+	r := s.recorders.all
+	if r != nil {
+		s.recorders.all = nil
+		atomic.AddInt32(&s.recorders.count, -1)
+	}
+	return r
+}
+
+// recorder of the room, nil is no recording
+func (s *Server) recorder(room string) *Recorder {
+	if atomic.LoadInt32(&s.recorders.count) == 0 {
+		return nil
+	}
+	s.recorders.mutex.RLock()
+	defer s.recorders.mutex.RUnlock()
+	if r, ok := s.recorders.rooms[room]; ok {
+		return r
+	}
+	return s.recorders.all
+}
+
+// Replay read the recording and send every record, time interval divided by speed
+// speed 1 is original pace, 2 is double, 0 is no wait. ctx done or send error stop it
+// Keep the sender name need send as it, e.g: in process Server.Join a bot named Record.Name
+func Replay(ctx context.Context, recording io.Reader, speed float64, send func(*Record) error) error {
+	reader := bufio.NewReader(recording)
+	var last time.Time
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		if speed > 0 && !last.IsZero() && rec.Time.After(last) {
+			timer := time.NewTimer(time.Duration(float64(rec.Time.Sub(last)) / speed))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		last = rec.Time
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := send(&rec); err != nil {
+			return err
+		}
+	}
+}
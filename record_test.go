@@ -0,0 +1,181 @@
+package lightcable
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRecord(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	conns := makeConns(t, server, "/test", "/test", "/test-2")
+	ws, ws2, ws3 := conns[0], conns[1], conns[2]
+
+	join := make(chan string, 3)
+	server.OnConnReady(func(c *Client) { join <- c.Name })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+	<-join
+	<-join
+	<-join
+
+	var recording bytes.Buffer
+	recorder := NewRecorder(&recording)
+	if err := server.Record("/test", recorder); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Record("/test", recorder); err != ErrRecording {
+		t.Error("Should already recording:", err)
+	}
+
+	// Not recording room
+	if err := ws3.WriteMessage(websocket.TextMessage, []byte("other")); err != nil {
+		t.Error(err)
+	}
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Error(err)
+	}
+	if _, data, err := ws2.ReadMessage(); err != nil || string(data) != "hello" {
+		t.Error("Should recv:", string(data), err)
+	}
+	if err := ws2.WriteMessage(websocket.BinaryMessage, []byte{1, 2}); err != nil {
+		t.Error(err)
+	}
+	if _, data, err := ws.ReadMessage(); err != nil || !bytes.Equal(data, []byte{1, 2}) {
+		t.Error("Should recv:", data, err)
+	}
+
+	if server.StopRecord("/test") != recorder || server.StopRecord("/test") != nil {
+		t.Error("Should stop recording once")
+	}
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("stopped")); err != nil {
+		t.Error(err)
+	}
+	if _, data, err := ws2.ReadMessage(); err != nil || string(data) != "stopped" {
+		t.Error("Should recv:", string(data), err)
+	}
+
+	cancel()
+	<-sign
+
+	if n := strings.Count(recording.String(), "\n"); n != 2 {
+		t.Fatalf("Should record 2 lines, but: %d\n%s", n, recording.String())
+	}
+
+	// Replay no wait
+	var records []Record
+	if err := Replay(context.Background(), &recording, 0, func(r *Record) error {
+		records = append(records, *r)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 ||
+		records[0].Room != "/test" || records[0].Code != websocket.TextMessage || string(records[0].Data) != "hello" ||
+		records[1].Code != websocket.BinaryMessage || !bytes.Equal(records[1].Data, []byte{1, 2}) ||
+		records[1].Time.Before(records[0].Time) {
+		t.Errorf("Replay: %+v", records)
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	var recording bytes.Buffer
+	recorder := NewRecorder(&recording)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		recorder.Append(&Record{
+			Room: "/test",
+			Code: websocket.TextMessage,
+			Data: []byte("x"),
+			Time: start.Add(time.Duration(i) * 200 * time.Millisecond),
+		})
+	}
+
+	// 400ms recording at 10x
+	now := time.Now()
+	count := 0
+	if err := Replay(context.Background(), bytes.NewReader(recording.Bytes()), 10, func(r *Record) error {
+		count++
+		return nil
+	}); err != nil || count != 3 {
+		t.Fatal("Replay:", count, err)
+	}
+	if d := time.Since(now); d < 40*time.Millisecond || d > 300*time.Millisecond {
+		t.Error("Should accelerated pace:", d)
+	}
+
+	// ctx done stop it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Replay(ctx, bytes.NewReader(recording.Bytes()), 1, func(r *Record) error {
+		return nil
+	}); err != context.Canceled {
+		t.Error("Should canceled:", err)
+	}
+}
+
+func TestRecordAll(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	conns := makeConns(t, server, "/test", "/test", "/test-2", "/test-2")
+
+	join := make(chan string, 4)
+	server.OnConnReady(func(c *Client) { join <- c.Name })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+	for range conns {
+		<-join
+	}
+
+	var all, room bytes.Buffer
+	recorder := NewRecorder(&all)
+	if err := server.RecordAll(recorder); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RecordAll(recorder); err != ErrRecording {
+		t.Error("Should already recording:", err)
+	}
+	// Room recorder first
+	if err := server.Record("/test", NewRecorder(&room)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, data := range []string{"room", "all"} {
+		if err := conns[i*2].WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+			t.Error(err)
+		}
+		if _, recv, err := conns[i*2+1].ReadMessage(); err != nil || string(recv) != data {
+			t.Error("Should recv:", string(recv), err)
+		}
+	}
+
+	cancel()
+	<-sign
+
+	if server.StopRecordAll() != recorder || server.StopRecordAll() != nil {
+		t.Error("Should stop recording once")
+	}
+	// No room recorder left by all rooms
+	if len(server.recorders.rooms) != 1 {
+		t.Error("Room recorders:", server.recorders.rooms)
+	}
+	if !strings.Contains(room.String(), `"room":"/test"`) || strings.Count(room.String(), "\n") != 1 {
+		t.Error("Room recording:", room.String())
+	}
+	if !strings.Contains(all.String(), `"room":"/test-2"`) || strings.Count(all.String(), "\n") != 1 {
+		t.Error("All recording:", all.String())
+	}
+}
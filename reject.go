@@ -0,0 +1,77 @@
+package lightcable
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Server lack of resources, client retry after seconds
+const retryAfter = 1
+
+// Rejection is the rejected websocket connection HTTP response
+// Code is 0, use 403 Forbidden; Body is empty, use status text
+type Rejection struct {
+	Code   int
+	Header http.Header
+	Body   string
+
+	// OnConnected callback already write the response
+	written bool
+}
+
+func (rej *Rejection) Error() string {
+	if rej.Body != "" {
+		return rej.Body
+	}
+	return http.StatusText(rej.code())
+}
+
+func (rej *Rejection) code() int {
+	if rej.Code == 0 {
+		return http.StatusForbidden
+	}
+	return rej.Code
+}
+
+func (rej *Rejection) write(w http.ResponseWriter) {
+	if rej.written {
+		return
+	}
+	for k, v := range rej.Header {
+		w.Header()[k] = v
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(rej.code())
+	io.WriteString(w, rej.Error())
+}
+
+func newUnavailable(reason string) *Rejection {
+	return &Rejection{
+		Code:   http.StatusServiceUnavailable,
+		Header: http.Header{"Retry-After": {strconv.Itoa(retryAfter)}},
+		Body:   reason,
+	}
+}
+
+// statusWriter record OnConnected callback written status code
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,104 @@
+package lightcable
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestReject(t *testing.T) {
+	server := New(DefaultConfig)
+	server.OnConnect(func(r *http.Request) (string, string, error) {
+		switch r.URL.Path {
+		case "/rejection":
+			return "", "", &Rejection{
+				Code:   http.StatusUnauthorized,
+				Header: http.Header{"X-Reason": {"token"}},
+				Body:   "need token",
+			}
+		case "/error":
+			return "", "", errors.New("error")
+		}
+		return r.URL.Path, "test", nil
+	})
+	rejects := make(chan int, 2)
+	server.OnReject(func(r *http.Request, rejection *Rejection) {
+		rejects <- rejection.Code
+	})
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	_, res, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/rejection"), nil)
+	if err == nil {
+		t.Fatal("Should reject")
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusUnauthorized || res.Header.Get("X-Reason") != "token" || string(body) != "need token" {
+		t.Errorf("Rejection response: %d, %v, %s", res.StatusCode, res.Header, body)
+	}
+	if code := <-rejects; code != http.StatusUnauthorized {
+		t.Error("OnReject code:", code)
+	}
+
+	if _, res, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/error"), nil); err == nil || res.StatusCode != http.StatusForbidden {
+		t.Error("Should reject 403:", err)
+	}
+	if code := <-rejects; code != http.StatusForbidden {
+		t.Error("OnReject code:", code)
+	}
+}
+
+func TestRejectConnected(t *testing.T) {
+	server := New(DefaultConfig)
+	server.OnConnected(func(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+		if r.URL.Path == "/write" {
+			w.WriteHeader(http.StatusTeapot)
+		}
+		return "", "", false
+	})
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	if _, res, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/test"), nil); err == nil || res.StatusCode != http.StatusForbidden {
+		t.Error("Should reject 403:", err)
+	}
+
+	if _, res, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/write"), nil); err == nil || res.StatusCode != http.StatusTeapot {
+		t.Error("Should keep callback response:", err)
+	}
+}
+
+func TestRejectBusy(t *testing.T) {
+	server := New(&Config{
+		SignBufferCount: 1,
+		CastBufferCount: 1,
+		Worker:          DefaultConfig.Worker,
+	})
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	// server no Run, register buffer will full
+	if _, _, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/test"), nil); err != nil {
+		t.Error(err)
+	}
+	// Dial return after upgrade, register maybe not yet
+	for i := 0; !server.shard("/test").full(); i++ {
+		if i == 100 {
+			t.Fatal("Register buffer should full")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, res, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/test"), nil)
+	if err == nil || res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") == "" {
+		t.Error("Should reject 503:", err)
+	}
+}
@@ -0,0 +1,107 @@
+package lightcable
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrRoomNotFound the room no exist, or the room closed
+var ErrRoomNotFound = errors.New("Room Not Found")
+
+// execRoom run function in the room worker threads
+func (s *Server) execRoom(ctx context.Context, room string, fn func(w *worker)) error {
+	var w *worker
+	sh := s.shard(room)
+	if err := sh.exec(ctx, func() { w = sh.worker[room] }); err != nil {
+		return err
+	}
+	if w == nil {
+		return ErrRoomNotFound
+	}
+
+	done := make(chan struct{})
+	select {
+	case w.call <- func() { fn(w); close(done) }:
+	case <-w.quit:
+		return ErrRoomNotFound
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-done
+	return nil
+}
+
+// Rooms return all rooms name
+func (s *Server) Rooms(ctx context.Context) ([]string, error) {
+	rooms := []string{}
+	for _, sh := range s.shards {
+		if err := sh.exec(ctx, func() {
+			for room := range sh.worker {
+				rooms = append(rooms, room)
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return rooms, nil
+}
+
+// Clients return the room all clients
+// Client is read only, Don't modify it
+func (s *Server) Clients(ctx context.Context, room string) (clients []*Client, err error) {
+	err = s.execRoom(ctx, room, func(w *worker) {
+		clients = make([]*Client, 0, len(w.clients))
+		for client := range w.clients {
+			clients = append(clients, client)
+		}
+	})
+	return
+}
+
+// Kick close the room all name is this clients, return kicked clients count
+// Kicked client will callback OnConnClose
+func (s *Server) Kick(ctx context.Context, room, name string) (count int, err error) {
+	err = s.execRoom(ctx, room, func(w *worker) {
+		for client := range w.clients {
+			if client.Name == name {
+				client.closeSend()
+				delete(w.clients, client)
+				count++
+			}
+		}
+	})
+	return
+}
+
+// CloseRoom close the room all clients, return closed clients count
+// Last client closed, the room linger or persistent, others callback OnRoomClose
+func (s *Server) CloseRoom(ctx context.Context, room string) (count int, err error) {
+	err = s.execRoom(ctx, room, func(w *worker) {
+		for client := range w.clients {
+			client.closeSend()
+			delete(w.clients, client)
+			count++
+		}
+	})
+	return
+}
+
+// CreateRoom create a persistent room, it exist without clients
+// The room already exist, make it persistent
+func (s *Server) CreateRoom(ctx context.Context, room string) error {
+	sh := s.shard(room)
+	return sh.exec(ctx, func() {
+		atomic.StoreInt32(&sh.open(room).persistent, 1)
+	})
+}
+
+// DeleteRoom close the room all clients and the room, return closed clients count
+// Ignore WorkerConfig.Linger and persistent, all clients closed, will callback OnRoomClose
+func (s *Server) DeleteRoom(ctx context.Context, room string) (count int, err error) {
+	err = s.execRoom(ctx, room, func(w *worker) {
+		atomic.StoreInt32(&w.persistent, 0)
+		count = w.expire()
+	})
+	return
+}
@@ -0,0 +1,137 @@
+package lightcable
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRoomLifecycle(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Rooms = []string{"/persistent"}
+	cfg.Worker.Linger = 200 * time.Millisecond
+	server := New(&cfg)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	ready := make(chan string, 4)
+	server.OnRoomReady(func(room string) { ready <- room })
+	closed := make(chan string, 4)
+	server.OnRoomClose(func(room string) { closed <- room })
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) { join <- c.Room })
+	leave := make(chan string)
+	server.OnConnClose(func(c *Client) { leave <- c.Room })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+
+	// Persistent room exist without clients
+	if room := <-ready; room != "/persistent" {
+		t.Error("Should ready persistent room:", room)
+	}
+
+	dial := func(room string) *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+room), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-join
+		return ws
+	}
+
+	// Last client left, the room linger
+	ws := dial("/linger")
+	if room := <-ready; room != "/linger" {
+		t.Error("Should ready room:", room)
+	}
+	ws.Close()
+	<-leave
+
+	// Join again in linger time, the room keep alive
+	ws = dial("/linger")
+	select {
+	case room := <-ready:
+		t.Error("Should not ready again:", room)
+	case room := <-closed:
+		t.Error("Should not close:", room)
+	default:
+	}
+	ws.Close()
+	<-leave
+
+	start := time.Now()
+	if room := <-closed; room != "/linger" {
+		t.Error("Should close room:", room)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Error("Should linger:", d)
+	}
+
+	rooms, err := server.Rooms(context.Background())
+	if err != nil || len(rooms) != 1 || rooms[0] != "/persistent" {
+		t.Error("Rooms:", rooms, err)
+	}
+
+	// Already exist, make it persistent
+	if err := server.CreateRoom(context.Background(), "/persistent"); err != nil {
+		t.Error(err)
+	}
+
+	// Delete persistent room, close its clients
+	ws = dial("/persistent")
+	count, err := server.DeleteRoom(context.Background(), "/persistent")
+	if err != nil || count != 1 {
+		t.Error("DeleteRoom:", count, err)
+	}
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Error("Should closed:", err)
+	}
+	<-leave
+	if room := <-closed; room != "/persistent" {
+		t.Error("Should close room:", room)
+	}
+	if _, err := server.DeleteRoom(context.Background(), "/persistent"); err != ErrRoomNotFound {
+		t.Error("Should not found:", err)
+	}
+
+	// Server close, persistent room also closed
+	if err := server.CreateRoom(context.Background(), "/again"); err != nil {
+		t.Error(err)
+	}
+	cancel()
+	if room := <-closed; room != "/again" {
+		t.Error("Should close room:", room)
+	}
+	<-sign
+}
+
+func TestRoomMaxLifetime(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Worker.MaxLifetime = 100 * time.Millisecond
+	server := New(&cfg)
+	conns := makeConns(t, server, "/test")
+
+	closed := make(chan string, 1)
+	server.OnRoomClose(func(room string) { closed <- room })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+
+	if _, _, err := conns[0].ReadMessage(); err == nil {
+		t.Error("Should closed:", err)
+	}
+	if room := <-closed; room != "/test" {
+		t.Error("Should close room:", room)
+	}
+
+	cancel()
+	<-sign
+}
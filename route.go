@@ -0,0 +1,112 @@
+package lightcable
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RoomRule extract room name from websocket request
+// Room source priority: Header > Query > URL path
+type RoomRule struct {
+	// Room from the request header, e.g: "X-Room"
+	Header string
+
+	// Room from the URL query parameter, e.g: "room" => "/ws?room=xxx" room: "xxx"
+	Query string
+
+	// Strip URL path prefix, e.g: "/ws" => "/ws/xxx" room: "/xxx"
+	// URL path no this prefix will reject 404, prefix is whole path segments, "/wsxxx" not match
+	Prefix string
+
+	// Match URL path (after strip prefix), use named capture "room"
+	// e.g: `^/(?P<room>[^/]+)/ws$` => "/xxx/ws" room: "xxx"
+	// No match will reject 404
+	Pattern *regexp.Regexp
+
+	// Clean URL path room, "/a/" and "//a" => "/a"
+	Clean bool
+
+	// Room name max length, 0 is unlimited
+	MaxLength int
+
+	// Room name allowed charset, no match will reject 400
+	// e.g: `^[a-zA-Z0-9/_-]+$`
+	Charset *regexp.Regexp
+}
+
+// RoomError is RoomRule extract room failed, Code is HTTP status code
+type RoomError struct {
+	Code   int
+	Reason string
+}
+
+func (e *RoomError) Error() string {
+	return e.Reason
+}
+
+// Room extract room name from the request, nil RoomRule is URL path
+// return error is *RoomError
+func (rule *RoomRule) Room(r *http.Request) (string, error) {
+	if rule == nil {
+		return r.URL.Path, nil
+	}
+
+	room, err := rule.extract(r)
+	if err != nil {
+		return "", err
+	}
+
+	if room == "" {
+		return "", &RoomError{http.StatusNotFound, "Room is empty"}
+	}
+	if rule.MaxLength > 0 && len(room) > rule.MaxLength {
+		return "", &RoomError{http.StatusBadRequest, fmt.Sprintf("Room length need less than %d", rule.MaxLength)}
+	}
+	if rule.Charset != nil && !rule.Charset.MatchString(room) {
+		return "", &RoomError{http.StatusBadRequest, "Room has invalid character"}
+	}
+	return room, nil
+}
+
+func (rule *RoomRule) extract(r *http.Request) (string, error) {
+	if rule.Header != "" {
+		return r.Header.Get(rule.Header), nil
+	}
+	if rule.Query != "" {
+		return r.URL.Query().Get(rule.Query), nil
+	}
+
+	room := r.URL.Path
+	if rule.Prefix != "" {
+		// Prefix is whole path segments, "/ws" not match "/wsxxx"
+		prefix := strings.TrimSuffix(rule.Prefix, "/")
+		if room != prefix && !strings.HasPrefix(room, prefix+"/") {
+			return "", &RoomError{http.StatusNotFound, "URL path need prefix: " + rule.Prefix}
+		}
+		room = strings.TrimPrefix(room, prefix)
+		if !strings.HasPrefix(room, "/") {
+			room = "/" + room
+		}
+	}
+
+	if rule.Pattern != nil {
+		match := rule.Pattern.FindStringSubmatch(room)
+		if match == nil {
+			return "", &RoomError{http.StatusNotFound, "URL path not match room pattern"}
+		}
+		room = match[0]
+		for i, name := range rule.Pattern.SubexpNames() {
+			if name == "room" {
+				room = match[i]
+			}
+		}
+	}
+
+	if rule.Clean && room != "" {
+		room = path.Clean(room)
+	}
+	return room, nil
+}
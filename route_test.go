@@ -0,0 +1,86 @@
+package lightcable
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRoomRule(t *testing.T) {
+	for _, c := range []struct {
+		rule   *RoomRule
+		url    string
+		header string
+		room   string
+		code   int
+	}{
+		{nil, "/a/", "", "/a/", 0},
+		{&RoomRule{Clean: true}, "/a/", "", "/a", 0},
+		{&RoomRule{Clean: true}, "//a//b/", "", "/a/b", 0},
+		{&RoomRule{Prefix: "/ws"}, "/ws/a", "", "/a", 0},
+		{&RoomRule{Prefix: "/ws"}, "/a", "", "", http.StatusNotFound},
+		{&RoomRule{Prefix: "/ws"}, "/wsfoo", "", "", http.StatusNotFound},
+		{&RoomRule{Prefix: "/ws/"}, "/ws/a", "", "/a", 0},
+		{&RoomRule{Query: "room"}, "/ws?room=a", "", "a", 0},
+		{&RoomRule{Query: "room"}, "/ws", "", "", http.StatusNotFound},
+		{&RoomRule{Header: "X-Room"}, "/ws", "a", "a", 0},
+		{&RoomRule{Pattern: regexp.MustCompile(`^/(?P<room>[^/]+)/ws$`)}, "/a/ws", "", "a", 0},
+		{&RoomRule{Pattern: regexp.MustCompile(`^/(?P<room>[^/]+)/ws$`)}, "/a/b", "", "", http.StatusNotFound},
+		{&RoomRule{Prefix: "/ws", Pattern: regexp.MustCompile(`^/[a-z]+$`)}, "/ws/abc", "", "/abc", 0},
+		{&RoomRule{MaxLength: 3}, "/abcd", "", "", http.StatusBadRequest},
+		{&RoomRule{Charset: regexp.MustCompile(`^[a-z/]+$`)}, "/a-b", "", "", http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(http.MethodGet, c.url, nil)
+		r.Header.Set("X-Room", c.header)
+		room, err := c.rule.Room(r)
+		if c.code != 0 {
+			if e, ok := err.(*RoomError); !ok || e.Code != c.code {
+				t.Errorf("%s: should error code: %d, but: %v", c.url, c.code, err)
+			}
+			continue
+		}
+		if err != nil || room != c.room {
+			t.Errorf("%s: should room: %s, but: %s, %v", c.url, c.room, room, err)
+		}
+	}
+}
+
+func TestServerRoomRule(t *testing.T) {
+	server := New(&Config{
+		SignBufferCount: 128,
+		CastBufferCount: 128,
+		Room:            &RoomRule{Prefix: "/ws", Clean: true},
+		Worker:          DefaultConfig.Worker,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Room
+	})
+	go server.Run(ctx)
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	if _, res, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/xxx"), nil); err == nil || res.StatusCode != http.StatusNotFound {
+		t.Error("Should reject 404:", err)
+	}
+
+	if _, _, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/ws/xxx/"), nil); err != nil {
+		t.Error(err)
+	}
+	if room := <-join; room != "/xxx" {
+		t.Error("Room should /xxx, but:", room)
+	}
+
+	cancel()
+	<-sign
+}
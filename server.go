@@ -3,12 +3,24 @@ package lightcable
 import (
 	"context"
 	"errors"
-	"io"
 	"net/http"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 )
 
+var (
+	// ErrBufferFull the server lack of resources
+	ErrBufferFull = errors.New("Buffer Always Full")
+
+	// ErrDropped message dropped by busy room, Config.BroadcastAllPolicy is PolicyDrop
+	ErrDropped = errors.New("Message Dropped By Busy Room")
+
+	// ErrNotRunning the server not Run yet or already closed
+	ErrNotRunning = errors.New("Server Not Running")
+)
+
 type readyState int8
 
 const (
@@ -22,25 +34,47 @@ const (
 // broadcast message, A Server auto create and manage multiple goroutines
 // every room create worker
 type Server struct {
+	// Stats.Dropped and Stats.StoreErrors, atomic uint64 first for 32-bit platforms alignment
+	dropped     uint64
+	storeErrors uint64
+
+	// atomic, 1 is Run started, TryBroadcast no wait a not running server
+	started int32
+
 	config WorkerConfig
-	worker map[string]*worker
+	shards []*shard
+
+	upgrader *websocket.Upgrader
+	limiter  *limiter
 
-	// Register requests from the clients.
-	register chan *Client
+	// Epoll engine, nil is goroutine engine
+	poller *poller
 
-	// Inbound messages from the clients.
-	broadcast chan Message
+	// Persistent room messages, maybe nil
+	store Store
 
-	// Inbound All Room Message
+	policy Policy
+
+	// Persistent rooms, create when Run
+	rooms []string
+
+	// Recording rooms
+	recorders recorders
+
+	// Wildcard subscription rooms, nil is disable
+	topics *topicTree
+
+	// Inbound All Room Message, fan out to all shards
 	broadcastAll chan Message
 
-	// Unregister requests from clients.
-	unregister chan *Client
+	// Closed when Run ctx done
+	done chan struct{}
 
 	readyState
 
 	onMessage   func(*Message)
-	onConnected func(w http.ResponseWriter, r *http.Request) (room, name string, ok bool)
+	onConnect   func(w http.ResponseWriter, r *http.Request) (room, name string, err error)
+	onReject    func(r *http.Request, rejection *Rejection)
 	onRoomReady func(room string)
 	onConnReady func(*Client)
 	onConnClose func(*Client)
@@ -50,74 +84,104 @@ type Server struct {
 
 // New creates a new Server.
 func New(cfg *Config) *Server {
-	return &Server{
+	n := cfg.Shards
+	if n <= 0 {
+		n = 1
+	}
+	shards := make([]*shard, n)
+	s := &Server{
 		config: cfg.Worker,
-		worker: make(map[string]*worker),
+		shards: shards,
+
+		upgrader: newUpgrader(cfg),
+		limiter:  newLimiter(cfg),
+
+		policy: cfg.BroadcastAllPolicy,
+		store:  cfg.Store,
+		rooms:  cfg.Rooms,
 
 		readyState: readyStateOpening,
 
-		register:     make(chan *Client, cfg.SignBufferCount),
-		broadcast:    make(chan Message, cfg.CastBufferCount),
-		unregister:   make(chan *Client, cfg.SignBufferCount),
 		broadcastAll: make(chan Message, cfg.CastBufferCount),
+		done:         make(chan struct{}),
 
 		onMessage: func(*Message) {},
-		onConnected: func(w http.ResponseWriter, r *http.Request) (room, name string, ok bool) {
-			return r.URL.Path, getUniqueID(), true
+		onConnect: func(w http.ResponseWriter, r *http.Request) (room, name string, err error) {
+			room, err = cfg.Room.Room(r)
+			if err != nil {
+				e := err.(*RoomError)
+				return "", "", &Rejection{Code: e.Code, Body: e.Reason}
+			}
+			return room, getUniqueID(), nil
 		},
+		onReject:    func(r *http.Request, rejection *Rejection) {},
 		onRoomReady: func(room string) {},
 		onConnReady: func(*Client) {},
 		onConnClose: func(*Client) {},
 		onRoomClose: func(room string) {},
 		onServClose: func() {},
 	}
+	for i := range shards {
+		shards[i] = newShard(s, cfg)
+	}
+	if cfg.Wildcard {
+		s.topics = newTopicTree()
+	}
+	if cfg.Engine == EngineEpoll {
+		// Not support platform, fallback goroutine engine
+		s.poller, _ = newPoller()
+	}
+	return s
 }
 
 // Run need use 'go server.Run(context.Background())' run daemon
 // in order to concurrency. server instance only a run
+// Every shard run in its own goroutine
 func (s *Server) Run(ctx context.Context) {
 	s.readyState = readyStateRunning
-	defer func() {
-		for {
-			// Last room, server onClose
-			if len(s.worker) == 0 && s.readyState == readyStateClosing {
-				s.onServClose()
-				s.readyState = readyStateClosed
-				return
-			}
+	atomic.StoreInt32(&s.started, 1)
+	if s.poller != nil {
+		go s.poller.run(ctx)
+	}
+
+	var wg sync.WaitGroup
+	for _, sh := range s.shards {
+		wg.Add(1)
+		go func(sh *shard) {
+			defer wg.Done()
+			sh.run(ctx)
+		}(sh)
+	}
 
-			delete(s.worker, (<-s.unregister).Room)
-		}
-	}()
 	for {
 		select {
-		// unregister must first
-		// close and open concurrency
-		case c := <-s.unregister:
-			delete(s.worker, c.Room)
-		case c := <-s.register:
-			c.worker = s.worker[c.Room]
-			if c.worker == nil {
-				c.worker = newWorker(c.Room, s)
-				go c.worker.run(ctx)
-				s.worker[c.Room] = c.worker
-			}
-			c.worker.register <- c
-		case m := <-s.broadcast:
-			if worker, ok := s.worker[m.Room]; ok {
-				worker.broadcast <- m
-			}
 		case m := <-s.broadcastAll:
-			for _, worker := range s.worker {
-
-				// This should not be blocked
+			for _, sh := range s.shards {
+				m.receipt.add()
+				if m.try {
+					select {
+					case sh.broadcastAll <- m:
+					default:
+						atomic.AddUint64(&s.dropped, 1)
+						m.receipt.drop()
+					}
+					continue
+				}
 				select {
-				case worker.broadcast <- m:
-				default:
+				case sh.broadcastAll <- m:
+				case <-ctx.Done():
+					m.receipt.deliver(0)
 				}
 			}
+			m.receipt.deliver(0)
 		case <-ctx.Done():
 			s.readyState = readyStateClosing
+			close(s.done)
+
+			// Last room, server onClose
+			wg.Wait()
+			s.onServClose()
+			s.readyState = readyStateClosed
 			return
 		}
 	}
@@ -127,45 +191,127 @@ func (s *Server) Run(ctx context.Context) {
 // creates new websocket connection
 // Maybe Create new Worker. worker == room
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if room, name, ok := s.onConnected(w, r); ok {
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, err.Error())
-			return
+	r, opts := withConnOptions(r)
+	room, name, err := s.onConnect(w, r)
+	if err != nil {
+		rejection, ok := err.(*Rejection)
+		if !ok {
+			rejection = &Rejection{Body: err.Error()}
 		}
+		s.reject(w, r, rejection)
+		return
+	}
+	if name == "" {
+		name = getUniqueID()
+	}
 
-		if err := s.addClient(&Client{
-			Room: room,
-			Name: name,
-			conn: conn,
-			send: make(chan Message, s.config.CastBufferCount),
-		}); err != nil {
-			// The server lack of resources: close the connection
-			conn.WriteMessage(websocket.CloseMessage, []byte{})
-		}
+	// The server lack of resources: reject before upgrade
+	if s.shard(room).full() {
+		s.reject(w, r, newUnavailable("Server Busy"))
+		return
+	}
+
+	ip := remoteIP(r)
+	if rejection := s.limiter.acquire(room, ip); rejection != nil {
+		s.reject(w, r, rejection)
+		return
+	}
+
+	conn, fc, bc, err := s.upgrade(w, r)
+	if err != nil {
+		s.limiter.release(room, ip)
+		// Upgrader already write the HTTP error response
+		s.onReject(r, &Rejection{Code: http.StatusBadRequest, Body: err.Error(), written: true})
+		return
+	}
+	if conn.Subprotocol() == mqttSubprotocol {
+		s.serveMQTT(conn, room, name, opts.perm, ip)
+		return
 	}
+
+	client := newClient(room, name, conn, s.config.CastBufferCount)
+	client.Perm = opts.perm
+	client.ip = ip
+	client.codec = codecOf(conn.Subprotocol())
+	client.attach(s.poller, fc)
+	client.batch = bc
+	if err := s.addClient(client); err != nil {
+		s.limiter.release(room, ip)
+		// The server lack of resources: close the connection
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()))
+		conn.Close()
+	}
+}
+
+// upgrade to websocket, epoll engine wrap the connection as frameConn, write batching as batchConn
+func (s *Server) upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, *frameConn, *batchConn, error) {
+	h := &hijacker{ResponseWriter: w, epoll: s.poller != nil, batch: s.config.WriteBatch > 1}
+	if !h.epoll && !h.batch {
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		return conn, nil, nil, err
+	}
+	conn, err := s.upgrader.Upgrade(h, r, nil)
+	return conn, h.conn, h.bc, err
+}
+
+func (s *Server) reject(w http.ResponseWriter, r *http.Request, rejection *Rejection) {
+	rejection.Code = rejection.code()
+	rejection.write(w)
+	s.onReject(r, rejection)
 }
 
-// Add a New Websocket Client
+// Add a New Websocket Client, permission is PermAll
+// Config.MQTT and the client speak MQTT, block until the connection closed
 func (s *Server) Upgrade(w http.ResponseWriter, r *http.Request, room, name string) error {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if s.shard(room).full() {
+		rejection := newUnavailable("Server Busy")
+		s.reject(w, r, rejection)
+		return rejection
+	}
+
+	ip := remoteIP(r)
+	if rejection := s.limiter.acquire(room, ip); rejection != nil {
+		s.reject(w, r, rejection)
+		return rejection
+	}
+
+	conn, fc, bc, err := s.upgrade(w, r)
 	if err != nil {
+		s.limiter.release(room, ip)
 		return err
 	}
-	return s.addClient(&Client{
-		Room: room,
-		Name: name,
-		conn: conn,
-		send: make(chan Message, s.config.CastBufferCount),
-	})
+	if conn.Subprotocol() == mqttSubprotocol {
+		s.serveMQTT(conn, room, name, PermAll, ip)
+		return nil
+	}
+	client := newClient(room, name, conn, s.config.CastBufferCount)
+	client.ip = ip
+	client.codec = codecOf(conn.Subprotocol())
+	client.attach(s.poller, fc)
+	client.batch = bc
+	if err := s.addClient(client); err != nil {
+		s.limiter.release(room, ip)
+		// The server lack of resources: close the connection
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()))
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// Stats return the server counters, for monitoring
+func (s *Server) Stats() Stats {
+	stats := s.limiter.stats()
+	stats.Dropped = atomic.LoadUint64(&s.dropped)
+	stats.StoreErrors = atomic.LoadUint64(&s.storeErrors)
+	return stats
 }
 
 func (s *Server) addClient(c *Client) (err error) {
 	select {
-	case s.register <- c:
+	case s.shard(c.Room).register <- c:
 	default:
-		err = errors.New("Buffer Always Full")
+		err = ErrBufferFull
 	}
 	return
 }
@@ -174,8 +320,9 @@ func (s *Server) addClient(c *Client) (err error) {
 // https://www.rfc-editor.org/rfc/rfc6455.html#section-11.8
 // code is websocket Opcode
 // name is custom name, this will be callback OnMessage
+// server busy or not run will block, use BroadcastContext or TryBroadcast
 func (s *Server) Broadcast(room, name string, code int, data []byte) {
-	s.broadcast <- Message{
+	s.shard(room).broadcast <- Message{
 		Name: name,
 		Room: room,
 		Code: code,
@@ -183,7 +330,31 @@ func (s *Server) Broadcast(room, name string, code int, data []byte) {
 	}
 }
 
+// BroadcastContext is Broadcast, but wait for delivered
+// return delivered clients count, ctx done return ctx.Err()
+func (s *Server) BroadcastContext(ctx context.Context, room, name string, code int, data []byte) (int, error) {
+	return s.publish(ctx, Message{
+		Name: name,
+		Room: room,
+		Code: code,
+		Data: data,
+	}, false)
+}
+
+// TryBroadcast is Broadcast, but never block on a busy server
+// return delivered clients count, server broadcast buffer is full return ErrBufferFull
+// Busy room dropped this message return ErrDropped, the server not running return ErrNotRunning
+func (s *Server) TryBroadcast(room, name string, code int, data []byte) (int, error) {
+	return s.tryPublish(Message{
+		Name: name,
+		Room: room,
+		Code: code,
+		Data: data,
+	}, false)
+}
+
 // BroadcastAll will all room all websocket connection send message
+// Busy room handle by Config.BroadcastAllPolicy
 func (s *Server) BroadcastAll(name string, code int, data []byte) {
 	s.broadcastAll <- Message{
 		Name: name,
@@ -192,20 +363,119 @@ func (s *Server) BroadcastAll(name string, code int, data []byte) {
 	}
 }
 
+// BroadcastAllContext is BroadcastAll, but wait for delivered
+// return delivered clients count
+// Busy room dropped this message return ErrDropped, ctx done return ctx.Err()
+func (s *Server) BroadcastAllContext(ctx context.Context, name string, code int, data []byte) (int, error) {
+	return s.publish(ctx, Message{
+		Name: name,
+		Code: code,
+		Data: data,
+	}, true)
+}
+
+// TryBroadcastAll is BroadcastAll, but never block on a busy server, ignore Config.BroadcastAllPolicy
+// return delivered clients count, server broadcast buffer is full return ErrBufferFull
+// Busy room dropped this message return ErrDropped, the server not running return ErrNotRunning
+func (s *Server) TryBroadcastAll(name string, code int, data []byte) (int, error) {
+	return s.tryPublish(Message{
+		Name: name,
+		Code: code,
+		Data: data,
+	}, true)
+}
+
+// publish send message and wait delivered, return delivered clients count
+// all is true, broadcast all rooms
+func (s *Server) publish(ctx context.Context, m Message, all bool) (int, error) {
+	m.receipt = newReceipt()
+	ch := s.broadcastAll
+	if !all {
+		ch = s.shard(m.Room).broadcast
+	}
+	select {
+	case ch <- m:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	return m.receipt.wait(ctx)
+}
+
+// tryPublish is publish, but buffer full not wait, shards never wait busy rooms for it
+// Workers never block on clients, wait delivered until the server closed
+// Shard blocked by PolicyBlock BroadcastAll maybe delay it
+func (s *Server) tryPublish(m Message, all bool) (int, error) {
+	select {
+	case <-s.done:
+		return 0, ErrNotRunning
+	default:
+		if atomic.LoadInt32(&s.started) == 0 {
+			return 0, ErrNotRunning
+		}
+	}
+
+	m.receipt = newReceipt()
+	m.try = true
+	ch := s.broadcastAll
+	if !all {
+		ch = s.shard(m.Room).broadcast
+	}
+	select {
+	case ch <- m:
+	default:
+		return 0, ErrBufferFull
+	}
+
+	select {
+	case <-m.receipt.done:
+		return m.receipt.wait(context.Background())
+	case <-s.done:
+		return int(atomic.LoadInt64(&m.receipt.count)), ErrNotRunning
+	}
+}
+
 // OnMessage will all Websocket Conn Recv Message will callback this function
 // This have Block worker. Block this room
+// WorkerConfig.PoolBuffers, Message.Data is reused after broadcast, need keep it copy it
 func (s *Server) OnMessage(fn func(*Message)) {
 	s.onMessage = fn
 }
 
 // OnConnected auth this websocket connection callback
 // ok: true Allows connection; false Reject connection
+// Reject and no write response, will response 403 Forbidden
+// Use SetPermission(r, perm) decide this connection permission
+// name is empty, use a unique ID
 // Maybe Concurrent. unique ID need self use sync.Mutex
 func (s *Server) OnConnected(fn func(w http.ResponseWriter, r *http.Request) (room, name string, ok bool)) {
-	s.onConnected = fn
+	s.onConnect = func(w http.ResponseWriter, r *http.Request) (string, string, error) {
+		sw := &statusWriter{ResponseWriter: w}
+		room, name, ok := fn(sw, r)
+		if !ok {
+			return "", "", &Rejection{Code: sw.code, written: sw.code != 0}
+		}
+		return room, name, nil
+	}
+}
+
+// OnConnect is OnConnected, but return structured rejection
+// err is *Rejection, response its status code, headers and body. others error response 403 Forbidden
+// Use SetPermission(r, perm) decide this connection permission
+// name is empty, use a unique ID
+// Maybe Concurrent. unique ID need self use sync.Mutex
+func (s *Server) OnConnect(fn func(r *http.Request) (room, name string, err error)) {
+	s.onConnect = func(w http.ResponseWriter, r *http.Request) (string, string, error) {
+		return fn(r)
+	}
+}
+
+// OnReject rejected websocket connection callback, for logging
+// Maybe Concurrent
+func (s *Server) OnReject(fn func(r *http.Request, rejection *Rejection)) {
+	s.onReject = fn
 }
 
-// OnRoomReady Create a new room successfully
+// OnRoomReady Create a new room successfully, first client join or persistent room created
 func (s *Server) OnRoomReady(fn func(room string)) {
 	s.onRoomReady = fn
 }
@@ -223,6 +493,7 @@ func (s *Server) OnConnClose(fn func(*Client)) {
 }
 
 // OnRoomClose worker all websocket connection closed, worker close
+// After WorkerConfig.Linger, persistent room only close by DeleteRoom or server close
 func (s *Server) OnRoomClose(fn func(room string)) {
 	s.onRoomClose = fn
 }
@@ -202,6 +202,29 @@ func TestServerCallback(t *testing.T) {
 	<-signServ
 }
 
+func TestServerEmptyName(t *testing.T) {
+	server := New(DefaultConfig)
+	server.OnConnect(func(r *http.Request) (string, string, error) {
+		return r.URL.Path, "", nil
+	})
+	makeConns(t, server, "/test", "/test")
+
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) { join <- c.Name })
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+
+	// Empty name is a unique ID
+	if name, name2 := <-join, <-join; name == "" || name2 == "" || name == name2 {
+		t.Errorf("Should unique ID: %q %q", name, name2)
+	}
+
+	cancel()
+	<-sign
+}
+
 func TestServerLocal(t *testing.T) {
 	config := DefaultConfig
 	config.Worker.Local = true
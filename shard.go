@@ -0,0 +1,171 @@
+package lightcable
+
+import (
+	"context"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// shard is a server threads, manage part of rooms
+// room by name hash to shard, a room always in the same shard
+type shard struct {
+	// Workers run with this context, set by run
+	ctx context.Context
+
+	server *Server
+	worker map[string]*worker
+
+	// Register requests from the clients.
+	register chan *Client
+
+	// Inbound messages from the clients.
+	broadcast chan Message
+
+	// Inbound All Room Message
+	broadcastAll chan Message
+
+	// Teardown requests from workers.
+	unregister chan *worker
+
+	// Run function in shard threads
+	call chan func()
+}
+
+func newShard(server *Server, cfg *Config) *shard {
+	return &shard{
+		server: server,
+		worker: make(map[string]*worker),
+
+		register:     make(chan *Client, cfg.SignBufferCount),
+		broadcast:    make(chan Message, cfg.CastBufferCount),
+		unregister:   make(chan *worker, cfg.SignBufferCount),
+		broadcastAll: make(chan Message, cfg.CastBufferCount),
+		call:         make(chan func()),
+	}
+}
+
+// run until ctx done and all rooms closed
+func (sh *shard) run(ctx context.Context) {
+	sh.ctx = ctx
+	for _, room := range sh.server.rooms {
+		if sh.server.shard(room) == sh {
+			atomic.StoreInt32(&sh.open(room).persistent, 1)
+		}
+	}
+
+	defer func() {
+		// Wait last room closed
+		for len(sh.worker) != 0 {
+			sh.remove(<-sh.unregister)
+		}
+	}()
+	for {
+		select {
+		// unregister must first
+		// close and open concurrency
+		case w := <-sh.unregister:
+			sh.remove(w)
+		case c := <-sh.register:
+			c.worker = sh.open(c.Room)
+			atomic.AddInt32(&c.worker.pending, 1)
+			c.worker.register <- c
+		case m := <-sh.broadcast:
+			if worker, ok := sh.worker[m.Room]; ok {
+				sh.send(worker, m, !m.try)
+			}
+			sh.server.forward(m)
+			m.receipt.deliver(0)
+		case m := <-sh.broadcastAll:
+			for _, worker := range sh.worker {
+				sh.send(worker, m, sh.server.policy == PolicyBlock && !m.try)
+			}
+			m.receipt.deliver(0)
+		case fn := <-sh.call:
+			fn()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send the message to the room worker
+// block wait the busy room, else busy room drop it, count in Stats.Dropped
+func (sh *shard) send(w *worker, m Message, block bool) {
+	m.receipt.add()
+	if block {
+		w.broadcast <- m
+		return
+	}
+	select {
+	case w.broadcast <- m:
+	default:
+		atomic.AddUint64(&sh.server.dropped, 1)
+		m.receipt.drop()
+	}
+}
+
+// open the room worker, no exist create it
+func (sh *shard) open(room string) *worker {
+	w := sh.worker[room]
+	if w == nil {
+		w = newWorker(room, sh)
+		go w.run(sh.ctx)
+		sh.worker[room] = w
+		sh.server.limiter.openRoom(room)
+		if sh.server.topics != nil && isWildcard(room) {
+			sh.server.topics.add(room, w)
+		}
+	}
+	return w
+}
+
+// remove the worker, if teardown request is still valid
+// Worker cancel it before receive register, so no pending register and
+// closing is not canceled, no client will join this worker
+func (sh *shard) remove(w *worker) {
+	if sh.worker[w.room] != w || atomic.LoadInt32(&w.pending) != 0 {
+		return
+	}
+	switch atomic.LoadInt32(&w.closing) {
+	case closingIdle:
+		if atomic.LoadInt32(&w.persistent) == 1 {
+			return
+		}
+	case closingForce:
+	default:
+		return
+	}
+	delete(sh.worker, w.room)
+	sh.server.limiter.closeRoom(w.room)
+	if sh.server.topics != nil && isWildcard(w.room) {
+		sh.server.topics.remove(w.room, w)
+	}
+	close(w.quit)
+}
+
+// full register buffer is full, new client can't join
+func (sh *shard) full() bool {
+	return len(sh.register) >= cap(sh.register)
+}
+
+// exec run function in shard threads
+func (sh *shard) exec(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	select {
+	case sh.call <- func() { fn(); close(done) }:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-done
+	return nil
+}
+
+// shard of the room
+func (s *Server) shard(room string) *shard {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(room))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
@@ -0,0 +1,68 @@
+package lightcable
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShards(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Shards = 4
+	server := New(&cfg)
+
+	rooms := make([]string, 8)
+	for i := range rooms {
+		rooms[i] = fmt.Sprintf("/test-%d", i)
+	}
+	conns := makeConns(t, server, rooms...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	for range rooms {
+		<-join
+	}
+
+	list, err := server.Rooms(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(list)
+	if fmt.Sprint(list) != fmt.Sprint(rooms) {
+		t.Error("Rooms:", list)
+	}
+
+	for i, room := range rooms {
+		if count, err := server.BroadcastContext(context.Background(), room, "server", websocket.TextMessage, []byte(room)); err != nil || count != 1 {
+			t.Error("BroadcastContext:", room, count, err)
+		}
+		if _, data, err := conns[i].ReadMessage(); err != nil || string(data) != room {
+			t.Error("Should recv room:", string(data), err)
+		}
+	}
+
+	if count, err := server.BroadcastAllContext(context.Background(), "server", websocket.TextMessage, []byte("all")); err != nil || count != len(rooms) {
+		t.Error("BroadcastAllContext:", count, err)
+	}
+	for _, conn := range conns {
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != "all" {
+			t.Error("Should recv all:", string(data), err)
+		}
+	}
+
+	cancel()
+	<-sign
+}
@@ -0,0 +1,123 @@
+package lightcable
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebRTC signaling message types, need WorkerConfig.Signaling
+//
+//	client send: {"type": "offer", "to": "peer", "sdp": "..."}
+//	             {"type": "answer", "to": "peer", "sdp": "..."}
+//	             {"type": "candidate", "to": "peer", "candidate": {...}}
+//	peer recv:   same message add "from": "sender name"
+//	on join:     {"type": "lightcable.peers", "peers": ["a", "b"]}
+//	others recv: {"type": "lightcable.join", "name": "c"}
+//	on leave:    {"type": "lightcable.leave", "name": "c"}
+//
+// Signaling message is private, not stored, recorded or forwarded to wildcard rooms
+// Others message broadcast as usual. Room capacity is WorkerConfig.MaxClients, e.g: 2 is 1:1 call
+const (
+	SignalOffer     = "offer"
+	SignalAnswer    = "answer"
+	SignalCandidate = "candidate"
+
+	SignalPeers = "lightcable.peers"
+	SignalJoin  = "lightcable.join"
+	SignalLeave = "lightcable.leave"
+)
+
+// peerMessage is a peer joined or left message
+type peerMessage struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// peersMessage is the room peers list, send to the joined client
+type peersMessage struct {
+	Type  string   `json:"type"`
+	Peers []string `json:"peers"`
+}
+
+// parseSignal client text message is offer, answer or candidate to a named peer
+// return the peer name and the message add "from"
+func parseSignal(message *Message) (string, []byte, bool) {
+	if message.client == nil || message.Code != websocket.TextMessage || !bytes.Contains(message.Data, []byte(`"to"`)) {
+		return "", nil, false
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(message.Data, &m); err != nil {
+		return "", nil, false
+	}
+	var typ, to string
+	json.Unmarshal(m["type"], &typ)
+	json.Unmarshal(m["to"], &to)
+	switch typ {
+	case SignalOffer, SignalAnswer, SignalCandidate:
+	default:
+		return "", nil, false
+	}
+	if to == "" {
+		return "", nil, false
+	}
+	m["from"], _ = json.Marshal(message.client.Name)
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", nil, false
+	}
+	return to, data, true
+}
+
+// signal the message is signaling message, replace it add "from" and set the peer name
+func signal(message *Message) {
+	to, data, ok := parseSignal(message)
+	if !ok {
+		return
+	}
+	message.Data = data
+	message.buffer.release()
+	message.buffer = nil
+	message.to = to
+}
+
+// announce the joined client the peers list, and others it joined
+func (w *worker) announce(client *Client) {
+	peers := make([]string, 0, len(w.clients))
+	for c := range w.clients {
+		if c != client {
+			peers = append(peers, c.Name)
+		}
+	}
+	data, _ := json.Marshal(peersMessage{Type: SignalPeers, Peers: peers})
+	w.notify(client, data)
+
+	data, _ = json.Marshal(peerMessage{Type: SignalJoin, Name: client.Name})
+	for c := range w.clients {
+		if c != client {
+			w.notify(c, data)
+		}
+	}
+}
+
+// announceLeave others the client left
+func (w *worker) announceLeave(client *Client) {
+	data, _ := json.Marshal(peerMessage{Type: SignalLeave, Name: client.Name})
+	for c := range w.clients {
+		w.notify(c, data)
+	}
+}
+
+// notify the client a room text message, full buffer drop it
+func (w *worker) notify(client *Client, data []byte) {
+	select {
+	case client.send <- client.encode(Message{
+		Room: w.room,
+		Code: websocket.TextMessage,
+		Data: data,
+	}, nil):
+		client.wake()
+	default:
+	}
+}
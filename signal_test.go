@@ -0,0 +1,145 @@
+package lightcable
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSignaling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightcable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	cfg := *DefaultConfig
+	cfg.Store = store
+	cfg.Wildcard = true
+	cfg.Worker.Signaling = true
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	server.OnConnected(func(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+		return r.URL.Path, r.URL.Query().Get("name"), true
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+
+	var recording bytes.Buffer
+	if err := server.Record("/call", NewRecorder(&recording)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Join one by one, peers list is deterministic
+	dial := func(name string) *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/call?name="+name), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-join
+		return ws
+	}
+	read := func(conn *websocket.Conn) map[string]interface{} {
+		var m map[string]interface{}
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	expectPeers := func(conn *websocket.Conn, peers ...string) {
+		m := read(conn)
+		var recv []string
+		for _, p := range m["peers"].([]interface{}) {
+			recv = append(recv, p.(string))
+		}
+		sort.Strings(recv)
+		if m["type"] != SignalPeers || strings.Join(recv, ",") != strings.Join(peers, ",") {
+			t.Errorf("Peers expect: %v, but: %v", peers, m)
+		}
+	}
+	expectPeer := func(conn *websocket.Conn, typ, name string) {
+		if m := read(conn); m["type"] != typ || m["name"] != name {
+			t.Errorf("Expect %s %s, but: %v", typ, name, m)
+		}
+	}
+
+	// Wildcard room subscriber
+	spy, _, err := websocket.DefaultDialer.Dial(makeWsProto(httpServer.URL+"/+?name=spy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-join
+	expectPeers(spy)
+
+	alice := dial("alice")
+	expectPeers(alice)
+	bob := dial("bob")
+	expectPeers(bob, "alice")
+	expectPeer(alice, SignalJoin, "bob")
+	carol := dial("carol")
+	expectPeers(carol, "alice", "bob")
+	expectPeer(alice, SignalJoin, "carol")
+	expectPeer(bob, SignalJoin, "carol")
+
+	// Offer only alice recv, add from
+	if err := bob.WriteMessage(websocket.TextMessage, []byte(`{"type": "offer", "to": "alice", "sdp": "secret"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if m := read(alice); m["type"] != SignalOffer || m["from"] != "bob" || m["sdp"] != "secret" {
+		t.Error("Offer:", m)
+	}
+
+	// Others message broadcast as usual, carol not recv the offer
+	if err := alice.WriteMessage(websocket.TextMessage, []byte(`{"type": "answer", "sdp": "v=0"}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*websocket.Conn{bob, carol, spy} {
+		if m := read(conn); m["type"] != SignalAnswer || m["from"] != nil {
+			t.Error("Broadcast:", m)
+		}
+	}
+
+	// Offer not stored and forwarded
+	records, err := server.History("/call", time.Time{}, 0)
+	if err != nil || len(records) != 1 || string(records[0].Data) != `{"type": "answer", "sdp": "v=0"}` {
+		t.Error("History:", records, err)
+	}
+
+	// Leave
+	bob.Close()
+	expectPeer(alice, SignalLeave, "bob")
+	expectPeer(carol, SignalLeave, "bob")
+
+	cancel()
+	<-sign
+
+	// Offer not recorded
+	if strings.Count(recording.String(), "\n") != 1 {
+		t.Error("Recording:", recording.String())
+	}
+}
@@ -0,0 +1,108 @@
+package lightcable
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// Room state control message types
+//
+//	client set:  {"type": "lightcable.state.set", "key": "k", "value": 1}
+//	             value is null or no value, delete the key
+//	room diff:   {"type": "lightcable.state.diff", "key": "k", "value": 1, "version": 2}
+//	on join:     {"type": "lightcable.state.snapshot", "version": 2, "state": {"k": {"value": 1, "version": 2}}}
+const (
+	StateSet      = "lightcable.state.set"
+	StateDiff     = "lightcable.state.diff"
+	StateSnapshot = "lightcable.state.snapshot"
+)
+
+var jsonNull = json.RawMessage("null")
+
+// StateValue is a room state key value
+// Version is the room state version when this key last written
+type StateValue struct {
+	Value   json.RawMessage `json:"value"`
+	Version uint64          `json:"version"`
+}
+
+// stateMessage is room state control message
+type stateMessage struct {
+	Type    string                `json:"type"`
+	Key     string                `json:"key,omitempty"`
+	Value   json.RawMessage       `json:"value,omitempty"`
+	Version uint64                `json:"version"`
+	State   map[string]StateValue `json:"state,omitempty"`
+}
+
+// roomState is a room key value map, last writer wins
+// version increase every write
+type roomState struct {
+	version uint64
+	values  map[string]StateValue
+}
+
+func newRoomState() *roomState {
+	return &roomState{values: make(map[string]StateValue)}
+}
+
+// set the key, value is null delete the key, return the diff message
+func (s *roomState) set(key string, value json.RawMessage) []byte {
+	s.version++
+	if len(value) == 0 || bytes.Equal(value, jsonNull) {
+		value = jsonNull
+		delete(s.values, key)
+	} else {
+		s.values[key] = StateValue{Value: value, Version: s.version}
+	}
+	data, _ := json.Marshal(stateMessage{
+		Type:    StateDiff,
+		Key:     key,
+		Value:   value,
+		Version: s.version,
+	})
+	return data
+}
+
+func (s *roomState) snapshot() map[string]StateValue {
+	values := make(map[string]StateValue, len(s.values))
+	for k, v := range s.values {
+		values[k] = v
+	}
+	return values
+}
+
+func (s *roomState) snapshotMessage() []byte {
+	data, _ := json.Marshal(stateMessage{
+		Type:    StateSnapshot,
+		Version: s.version,
+		State:   s.snapshot(),
+	})
+	return data
+}
+
+// parseStateSet client text message is room state set control message
+func parseStateSet(message *Message) (*stateMessage, bool) {
+	if message.client == nil || message.Code != websocket.TextMessage || !bytes.Contains(message.Data, []byte(StateSet)) {
+		return nil, false
+	}
+	var m stateMessage
+	if err := json.Unmarshal(message.Data, &m); err != nil || m.Type != StateSet || m.Key == "" {
+		return nil, false
+	}
+	return &m, true
+}
+
+// RoomState return the room state snapshot
+// Need WorkerConfig.State, the room no exist return ErrRoomNotFound
+func (s *Server) RoomState(ctx context.Context, room string) (values map[string]StateValue, err error) {
+	err = s.execRoom(ctx, room, func(w *worker) {
+		if w.state != nil {
+			values = w.state.snapshot()
+		}
+	})
+	return
+}
@@ -0,0 +1,122 @@
+package lightcable
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRoomState(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Worker.State = true
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	conns := makeConns(t, server, "/test", "/test")
+	ws, ws2 := conns[0], conns[1]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() {
+		sign <- true
+	})
+	join := make(chan string)
+	server.OnConnReady(func(c *Client) {
+		join <- c.Name
+	})
+	go server.Run(ctx)
+
+	// Need wait for connection ready
+	<-join
+	<-join
+
+	read := func(conn *websocket.Conn) stateMessage {
+		var m stateMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	// Empty snapshot on join
+	for _, conn := range []*websocket.Conn{ws, ws2} {
+		if m := read(conn); m.Type != StateSnapshot || m.Version != 0 || len(m.State) != 0 {
+			t.Errorf("Snapshot: %+v", m)
+		}
+	}
+
+	// Diff to all clients, include the sender
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"type": "lightcable.state.set", "key": "a", "value": {"x": 1}}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*websocket.Conn{ws, ws2} {
+		if m := read(conn); m.Type != StateDiff || m.Key != "a" || string(m.Value) != `{"x":1}` || m.Version != 1 {
+			t.Errorf("Diff: %+v, %s", m, m.Value)
+		}
+	}
+
+	// Last writer wins
+	if err := ws2.WriteMessage(websocket.TextMessage, []byte(`{"type": "lightcable.state.set", "key": "a", "value": 2}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*websocket.Conn{ws, ws2} {
+		if m := read(conn); m.Type != StateDiff || m.Key != "a" || string(m.Value) != "2" || m.Version != 2 {
+			t.Errorf("Diff: %+v, %s", m, m.Value)
+		}
+	}
+
+	// Not control message, broadcast as usual
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"type": "chat"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, data, err := ws2.ReadMessage(); err != nil || string(data) != `{"type": "chat"}` {
+		t.Error("Should recv chat:", string(data), err)
+	}
+
+	state, err := server.RoomState(ctx, "/test")
+	if err != nil || len(state) != 1 || string(state["a"].Value) != "2" || state["a"].Version != 2 {
+		t.Errorf("RoomState: %+v, %v", state, err)
+	}
+
+	// Snapshot on join
+	ws3 := makeConns(t, server, "/test")[0]
+	<-join
+	if m := read(ws3); m.Type != StateSnapshot || m.Version != 2 || string(m.State["a"].Value) != "2" {
+		t.Errorf("Snapshot: %+v", m)
+	}
+
+	// Delete
+	if err := ws3.WriteMessage(websocket.TextMessage, []byte(`{"type": "lightcable.state.set", "key": "a", "value": null}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*websocket.Conn{ws, ws2, ws3} {
+		if m := read(conn); m.Type != StateDiff || m.Key != "a" || string(m.Value) != "null" || m.Version != 3 {
+			t.Errorf("Diff: %+v, %s", m, m.Value)
+		}
+	}
+	if state, err := server.RoomState(ctx, "/test"); err != nil || len(state) != 0 {
+		t.Errorf("RoomState: %+v, %v", state, err)
+	}
+
+	if _, err := server.RoomState(ctx, "/none"); err != ErrRoomNotFound {
+		t.Error("Should ErrRoomNotFound:", err)
+	}
+
+	cancel()
+	<-sign
+}
+
+func TestRoomStateMessage(t *testing.T) {
+	s := newRoomState()
+	var m stateMessage
+	if err := json.Unmarshal(s.set("a", json.RawMessage(`"x"`)), &m); err != nil || m.Type != StateDiff || m.Version != 1 {
+		t.Errorf("Diff: %+v, %v", m, err)
+	}
+	if err := json.Unmarshal(s.set("b", nil), &m); err != nil || string(m.Value) != "null" || m.Version != 2 {
+		t.Errorf("Diff: %+v, %v", m, err)
+	}
+	if values := s.snapshot(); len(values) != 1 || values["a"].Version != 1 {
+		t.Errorf("Snapshot: %+v", values)
+	}
+}
@@ -0,0 +1,63 @@
+package lightcable
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoStore the server no Config.Store
+var ErrNoStore = errors.New("No Store")
+
+// Record is a stored room message
+type Record struct {
+	Room string    `json:"room"`
+	Name string    `json:"name"`
+	Code int       `json:"code"`
+	Data []byte    `json:"data"`
+	Time time.Time `json:"time"`
+}
+
+// Store persistent room messages, for audit and replay
+type Store interface {
+	// Append a room message, called by room worker, need fast
+	// Maybe Concurrent. WorkerConfig.PoolBuffers, Record.Data will be reused, copy it if need keep
+	Append(r *Record) error
+
+	// Query the room messages after since, time ascending, max limit, 0 is unlimited
+	Query(room string, since time.Time, limit int) ([]Record, error)
+}
+
+// History query the room messages after since, time ascending, max limit, 0 is unlimited
+// no Config.Store return ErrNoStore
+func (s *Server) History(room string, since time.Time, limit int) ([]Record, error) {
+	if s.store == nil {
+		return nil, ErrNoStore
+	}
+	return s.store.Query(room, since, limit)
+}
+
+// append the message to store and the room recorder, error count in Stats.StoreErrors
+func (s *Server) append(room string, m *Message) {
+	recorder := s.recorder(room)
+	if s.store == nil && recorder == nil {
+		return
+	}
+	record := &Record{
+		Room: room,
+		Name: m.Name,
+		Code: m.Code,
+		Data: m.Data,
+		Time: time.Now(),
+	}
+	if s.store != nil {
+		if err := s.store.Append(record); err != nil {
+			atomic.AddUint64(&s.storeErrors, 1)
+		}
+	}
+	if recorder != nil {
+		if err := recorder.Append(record); err != nil {
+			atomic.AddUint64(&s.storeErrors, 1)
+		}
+	}
+}
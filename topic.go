@@ -0,0 +1,178 @@
+package lightcable
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Topic wildcards, room levels split by "/"
+const (
+	// Match a single level, "game/+/chat" match "game/123/chat"
+	WildcardLevel = "+"
+	// Match all remaining levels and the parent, "game/#" match "game" and "game/123/chat"
+	// Must be the last level
+	WildcardMulti = "#"
+)
+
+// isWildcard the room is a wildcard subscription
+// "#" not the last level, the room is a plain room
+func isWildcard(room string) bool {
+	if !strings.ContainsAny(room, WildcardLevel+WildcardMulti) {
+		return false
+	}
+	wildcard := false
+	for rest := room; ; {
+		level, next, last := splitLevel(rest)
+		switch level {
+		case WildcardMulti:
+			return last
+		case WildcardLevel:
+			wildcard = true
+		}
+		if last {
+			return wildcard
+		}
+		rest = next
+	}
+}
+
+// splitLevel first level and remaining levels, last is true no more level
+func splitLevel(topic string) (level, rest string, last bool) {
+	if i := strings.IndexByte(topic, '/'); i >= 0 {
+		return topic[:i], topic[i+1:], false
+	}
+	return topic, "", true
+}
+
+// topicTree is the wildcard subscription rooms trie
+// The shard add and remove, the senders match concurrency
+type topicTree struct {
+	// atomic, subscription rooms count, 0 is skip match
+	count int32
+
+	mutex sync.RWMutex
+	root  *topicNode
+}
+
+type topicNode struct {
+	children map[string]*topicNode
+
+	// The wildcard room worker, nil is only a path
+	worker *worker
+}
+
+func newTopicTree() *topicTree {
+	return &topicTree{root: &topicNode{}}
+}
+
+// add the wildcard room worker
+func (t *topicTree) add(room string, w *worker) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	node := t.root
+	for rest := room; ; {
+		level, next, last := splitLevel(rest)
+		child := node.children[level]
+		if child == nil {
+			if node.children == nil {
+				node.children = make(map[string]*topicNode)
+			}
+			child = &topicNode{}
+			node.children[level] = child
+		}
+		node = child
+		if last {
+			break
+		}
+		rest = next
+	}
+	if node.worker == nil {
+		atomic.AddInt32(&t.count, 1)
+	}
+	node.worker = w
+}
+
+// remove the wildcard room worker, prune empty path
+func (t *topicTree) remove(room string, w *worker) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.root.remove(room, w) {
+		atomic.AddInt32(&t.count, -1)
+	}
+}
+
+// remove return true removed, the empty child deleted by parent
+func (n *topicNode) remove(topic string, w *worker) bool {
+	level, rest, last := splitLevel(topic)
+	child := n.children[level]
+	if child == nil {
+		return false
+	}
+
+	removed := false
+	if last {
+		if removed = child.worker == w; removed {
+			child.worker = nil
+		}
+	} else {
+		removed = child.remove(rest, w)
+	}
+	if child.worker == nil && len(child.children) == 0 {
+		delete(n.children, level)
+	}
+	return removed
+}
+
+// match the concrete room, callback all matched wildcard room workers
+// Callback under the read lock, the worker not quit
+func (t *topicTree) match(room string, fn func(*worker)) {
+	if atomic.LoadInt32(&t.count) == 0 {
+		return
+	}
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	t.root.match(room, false, fn)
+}
+
+// match remaining levels, end is true no more level
+func (n *topicNode) match(topic string, end bool, fn func(*worker)) {
+	if child := n.children[WildcardMulti]; child != nil && child.worker != nil {
+		fn(child.worker)
+	}
+	if end {
+		if n.worker != nil {
+			fn(n.worker)
+		}
+		return
+	}
+
+	level, rest, last := splitLevel(topic)
+	if child := n.children[WildcardLevel]; child != nil {
+		child.match(rest, last, fn)
+	}
+	if child := n.children[level]; child != nil {
+		child.match(rest, last, fn)
+	}
+}
+
+// forward the concrete room message to matched wildcard rooms
+// This should not be blocked, busy room drop it, count in Stats.Dropped
+func (s *Server) forward(m Message) {
+	if s.topics == nil || isWildcard(m.Room) {
+		return
+	}
+	m.forwarded = true
+	s.topics.match(m.Room, func(w *worker) {
+		m.receipt.add()
+		m.buffer.retain()
+		select {
+		case w.broadcast <- m:
+		default:
+			m.buffer.release()
+			atomic.AddUint64(&s.dropped, 1)
+			m.receipt.drop()
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package lightcable
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestIsWildcard(t *testing.T) {
+	for room, wildcard := range map[string]bool{
+		"/game/123/chat": false,
+		"/game/+/chat":   true,
+		"/game/#":        true,
+		"#":              true,
+		"+":              true,
+		"/game/#/chat":   false,
+		"/game/a+b":      false,
+		"/game/a#":       false,
+	} {
+		if isWildcard(room) != wildcard {
+			t.Error("isWildcard:", room, !wildcard)
+		}
+	}
+}
+
+func TestTopicTree(t *testing.T) {
+	tree := newTopicTree()
+	rooms := []string{"/game/+/chat", "/game/#", "#", "/game/+", "/+/+/+", "/other/#"}
+	workers := make(map[*worker]string)
+	for _, room := range rooms {
+		w := &worker{room: room}
+		workers[w] = room
+		tree.add(room, w)
+	}
+
+	match := func(room string) []string {
+		matched := []string{}
+		tree.match(room, func(w *worker) { matched = append(matched, workers[w]) })
+		sort.Strings(matched)
+		return matched
+	}
+	for room, expect := range map[string][]string{
+		"/game/123/chat": {"#", "/+/+/+", "/game/#", "/game/+/chat"},
+		"/game/123":      {"#", "/game/#", "/game/+"},
+		"/game":          {"#", "/game/#"},
+		"/other":         {"#", "/other/#"},
+		"/none/1/2/3":    {"#"},
+	} {
+		if matched := match(room); len(matched) != len(expect) {
+			t.Error("match:", room, matched, expect)
+		} else {
+			for i := range expect {
+				if matched[i] != expect[i] {
+					t.Error("match:", room, matched, expect)
+				}
+			}
+		}
+	}
+
+	for w, room := range workers {
+		tree.remove(room, w)
+	}
+	if tree.count != 0 || len(tree.root.children) != 0 {
+		t.Error("Should prune all:", tree.count, tree.root.children)
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Wildcard = true
+	cfg.Worker.Local = false
+	server := New(&cfg)
+	// "#" is URL fragment, need escape
+	conns := makeConns(t, server, "/game/1/chat", "/game/+/chat", "/game/%23")
+	ws, sub, subAll := conns[0], conns[1], conns[2]
+
+	join := make(chan string, 3)
+	server.OnConnReady(func(c *Client) { join <- c.Room })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sign := make(chan bool)
+	server.OnServClose(func() { sign <- true })
+	go server.Run(ctx)
+	for i := 0; i < 3; i++ {
+		<-join
+	}
+
+	// Client message in concrete room
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("chat 1")); err != nil {
+		t.Error(err)
+	}
+	for _, c := range []*websocket.Conn{sub, subAll} {
+		if _, data, err := c.ReadMessage(); err != nil || string(data) != "chat 1" {
+			t.Error("Should recv:", string(data), err)
+		}
+	}
+
+	// Server broadcast to the room no clients
+	count, err := server.BroadcastContext(context.Background(), "/game/2/chat", "server", websocket.TextMessage, []byte("chat 2"))
+	if err != nil || count != 2 {
+		t.Error("Broadcast:", count, err)
+	}
+	for _, c := range []*websocket.Conn{sub, subAll} {
+		if _, data, err := c.ReadMessage(); err != nil || string(data) != "chat 2" {
+			t.Error("Should recv:", string(data), err)
+		}
+	}
+
+	// Only "#" matched
+	if count, err := server.TryBroadcast("/game/2/info", "server", websocket.TextMessage, []byte("info")); err != nil || count != 1 {
+		t.Error("TryBroadcast:", count, err)
+	}
+	if _, data, err := subAll.ReadMessage(); err != nil || string(data) != "info" {
+		t.Error("Should recv:", string(data), err)
+	}
+
+	cancel()
+	<-sign
+}
@@ -2,11 +2,32 @@ package lightcable
 
 import (
 	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Teardown request, worker to shard
+const (
+	closingNone int32 = iota
+	// No clients, persistent room keep alive
+	closingIdle
+	// Server closed, room expired or deleted
+	closingForce
 )
 
 type worker struct {
+	// atomic, shard routed register requests, worker not received
+	pending int32
+	// atomic, teardown request, closingNone is canceled
+	closing int32
+	// atomic, 1 is persistent room, exist without clients
+	persistent int32
+
 	room   string
 	server *Server
+	shard  *shard
 
 	// Registered clients.
 	clients map[*Client]bool
@@ -19,67 +40,260 @@ type worker struct {
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Run function in worker threads
+	call chan func()
+
+	// Room state, nil is disable
+	state *roomState
+
+	// Shard confirmed teardown, worker exit
+	quit chan struct{}
+
+	// Registered and not unregistered clients, include kicked
+	members int
+
+	// Room expired or deleted or server closed, no more clients
+	closed bool
+
+	// Last client left, wait it before close
+	linger *time.Timer
 }
 
-func newWorker(room string, server *Server) *worker {
-	return &worker{
+func newWorker(room string, shard *shard) *worker {
+	server := shard.server
+	w := &worker{
 		room:   room,
 		server: server,
+		shard:  shard,
 
 		clients:    make(map[*Client]bool),
 		register:   make(chan *Client, server.config.SignBufferCount),
 		broadcast:  make(chan Message, server.config.CastBufferCount),
 		unregister: make(chan *Client, server.config.SignBufferCount),
+		call:       make(chan func()),
+		quit:       make(chan struct{}),
 	}
+	if server.config.State {
+		w.state = newRoomState()
+	}
+	return w
 }
 
 func (w *worker) run(ctx context.Context) {
-	defer w.server.onRoomClose(w.room)
+	// This in order to noblock server threads, use worker threads callback
+	w.server.onRoomReady(w.room)
+
+	var lifetime <-chan time.Time
+	if w.server.config.MaxLifetime > 0 {
+		timer := time.NewTimer(w.server.config.MaxLifetime)
+		defer timer.Stop()
+		lifetime = timer.C
+	}
+
+	done := ctx.Done()
 	for {
+		var linger <-chan time.Time
+		if w.linger != nil {
+			linger = w.linger.C
+		}
+
 		select {
 		case client := <-w.register:
-			if len(w.clients) == 0 {
-				// This in order to noblock server threads, use worker threads callback
-				w.server.onRoomReady(w.room)
+			// Cancel teardown before received, look shard.remove
+			atomic.StoreInt32(&w.closing, closingNone)
+			atomic.AddInt32(&w.pending, -1)
+			if w.linger != nil {
+				w.linger.Stop()
+				w.linger = nil
 			}
 
+			w.members++
 			w.clients[client] = true
 
-			go client.readPump()
-			go client.writePump(ctx)
+			client.start(ctx)
+
+			// Full state snapshot, before any diff
+			if w.state != nil {
+				w.notify(client, w.state.snapshotMessage())
+			}
+
+			// Peers list to it, and others it joined
+			if w.server.config.Signaling {
+				w.announce(client)
+			}
 
 			// client has two threads
 			// So execute the callback here
 			w.server.onConnReady(client)
+
+			// The room is closing, no more clients
+			if w.closed {
+				client.closeSend()
+				delete(w.clients, client)
+			}
 		case client := <-w.unregister:
 			if _, ok := w.clients[client]; ok {
 				delete(w.clients, client)
-				close(client.send)
+				client.closeSend()
+			}
+			w.members--
+			// Virtual client not count in limiter
+			if client.handler == nil {
+				w.server.limiter.release(client.Room, client.ip)
 			}
 
 			// client has two threads
 			// So execute the callback here
 			w.server.onConnClose(client)
 
-			// Last client, need close this room
-			if len(w.clients) == 0 {
-				w.server.unregister <- client
+			if w.server.config.Signaling {
+				w.announceLeave(client)
+			}
+
+			// Last client, maybe need close this room
+			if w.members == 0 {
+				w.idle()
+			}
+		case <-linger:
+			w.linger = nil
+			w.close(closingIdle)
+		case <-lifetime:
+			w.expire()
+		case <-done:
+			// Server closed, ctx.Done() always ready
+			done = nil
+			w.closed = true
+			if w.members == 0 {
+				w.close(closingForce)
+			}
+		case <-w.quit:
+			// This in order to noblock server threads, use worker threads callback
+			w.server.onRoomClose(w.room)
 
-				// This in order to noblock server threads, use worker threads callback
-				w.server.onRoomClose(w.room)
-				return
+			// Messages already routed to this room, nobody can receive
+			for {
+				select {
+				case message := <-w.broadcast:
+					message.receipt.deliver(0)
+					message.buffer.release()
+				default:
+					return
+				}
 			}
 		case message := <-w.broadcast:
+			// Forwarded message belong to the concrete room, signaling message is private
+			if !message.forwarded && message.to == "" {
+				if w.state != nil {
+					w.setState(&message)
+				}
+				w.server.append(w.room, &message)
+			}
+
+			count := 0
+			// Codec encoded frames, lazy create
+			var frames []*codecFrame
 			for client := range w.clients {
-				if w.server.config.Local || message.conn != client.conn {
+				// Signaling message only the named peer recv
+				if !client.Perm.CanSubscribe() || (message.to != "" && client.Name != message.to) {
+					continue
+				}
+				if w.server.config.Local || message.client != client {
+					var m Message
+					if client.codec != nil {
+						// Encode once per codec, same codec clients share it
+						if frames == nil {
+							frames = make([]*codecFrame, len(codecs))
+						}
+						m = client.encode(message, frames)
+					} else {
+						// Frame once, all clients share it
+						if message.prepared == nil && !w.server.config.noPrepare {
+							message.prepared = prepare(message)
+						}
+						m = message
+					}
+
+					// Recipient reference, release after written
+					message.buffer.retain()
 					select {
-					case client.send <- message:
+					case client.send <- m:
+						client.wake()
+						count++
 					default:
-						close(client.send)
+						message.buffer.release()
+						client.closeSend()
 						delete(w.clients, client)
 					}
 				}
 			}
+			message.receipt.deliver(count)
+
+			// Sender reference
+			message.buffer.release()
+		case fn := <-w.call:
+			fn()
 		}
 	}
 }
+
+// idle the room no clients, linger or close
+func (w *worker) idle() {
+	switch {
+	case w.closed:
+		w.close(closingForce)
+	case atomic.LoadInt32(&w.persistent) == 1:
+	case w.server.config.Linger > 0:
+		w.linger = time.NewTimer(w.server.config.Linger)
+	default:
+		w.close(closingIdle)
+	}
+}
+
+// close request shard teardown this room, shard confirm it by close quit
+// New client joined before confirm, teardown canceled
+func (w *worker) close(closing int32) {
+	atomic.StoreInt32(&w.closing, closing)
+	w.shard.unregister <- w
+}
+
+// expire close all clients and the room, return closed clients count
+// The room closed after all clients unregistered
+func (w *worker) expire() (count int) {
+	w.closed = true
+	if w.linger != nil {
+		w.linger.Stop()
+		w.linger = nil
+	}
+	for client := range w.clients {
+		client.closeSend()
+		delete(w.clients, client)
+		count++
+	}
+	if w.members == 0 {
+		w.close(closingForce)
+	}
+	return
+}
+
+// prepare websocket frame, error is nil, client fallback WriteMessage
+func prepare(message Message) *websocket.PreparedMessage {
+	prepared, err := websocket.NewPreparedMessage(message.Code, message.Data)
+	if err != nil {
+		return nil
+	}
+	return prepared
+}
+
+// setState the message is state set control message, replace it as the diff message
+// The diff send to all clients include the sender
+func (w *worker) setState(message *Message) {
+	m, ok := parseStateSet(message)
+	if !ok {
+		return
+	}
+	message.Data = w.state.set(m.Key, m.Value)
+	message.client = nil
+	message.buffer.release()
+	message.buffer = nil
+}